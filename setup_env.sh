@@ -21,3 +21,5 @@ fi
 go get -u github.com/nsf/gocode
 go get -u github.com/smartystreets/goconvey
 go get -u github.com/puerkitobio/goquery
+go get -u go.etcd.io/bbolt
+go get -u gopkg.in/yaml.v3
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"wapbot.co.uk/crawler"
+)
+
+/*
+ * The check-links command prints the links to broken pages. It exits
+ * with status 1 if there are any and 2 if the crawl could not be made.
+ */
+func runCheckLinks(fs *flag.FlagSet) int {
+	var roots []*crawler.Page
+	if fs.NArg() > 0 {
+		for _, path := range fs.Args() {
+			root, err := loadCrawl(path)
+			if err != nil {
+				slog.Error("unable to load crawl", "path", path, "error", err)
+				return 2
+			}
+			roots = append(roots, root)
+		}
+	} else {
+		s := newCrawlSession()
+		if s == nil {
+			return 2
+		}
+		defer s.close()
+
+		var ok bool
+		roots, ok = s.run()
+		if !ok {
+			return 2
+		}
+	}
+
+	count := 0
+	for _, root := range roots {
+		for _, l := range crawler.BrokenLinks(root) {
+			fmt.Printf("%s -> %s (%s)\n", l.Source, l.Target, l.Reason)
+			count++
+		}
+	}
+
+	if count > 0 {
+		fmt.Printf("Broken links: %d\n", count)
+		return 1
+	}
+
+	fmt.Println("No broken links")
+	return 0
+}
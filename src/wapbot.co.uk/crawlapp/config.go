@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"wapbot.co.uk/crawler"
+)
+
+/*
+ * The settings of a site from the config file. Unset settings are nil.
+ */
+type siteConfig struct {
+	seeds       []*url.URL
+	scope       *url.URL
+	delay       *time.Duration
+	concurrency *int
+}
+
+/*
+ * A config file. Every top level setting is the name of a flag and is
+ * applied to it as it is loaded; the sites section is kept here.
+ */
+type config struct {
+	sites []*siteConfig
+}
+
+/*
+ * Collects the problems found in a config file, each with its line.
+ */
+type configErrors []string
+
+func (e *configErrors) add(node *yaml.Node, format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf("line %d: %s", node.Line, fmt.Sprintf(format, args...)))
+}
+
+/*
+ * Return the value of a scalar with environment variables expanded.
+ */
+func (e *configErrors) expand(node *yaml.Node) (string, bool) {
+	if node.Kind != yaml.ScalarNode {
+		e.add(node, "expected a single value")
+		return "", false
+	}
+
+	ok := true
+	value := os.Expand(node.Value, func(name string) string {
+		value, exists := os.LookupEnv(name)
+		if !exists {
+			e.add(node, "environment variable %s is not set", name)
+			ok = false
+		}
+		return value
+	})
+
+	return value, ok
+}
+
+/*
+ * Parse a URL to crawl.
+ */
+func (e *configErrors) url(node *yaml.Node) *url.URL {
+	value, ok := e.expand(node)
+	if !ok {
+		return nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.add(node, "%q is not an http or https URL", value)
+		return nil
+	}
+
+	return u
+}
+
+/*
+ * Parse a site from the sites section. A site is either just its URL or
+ * a mapping of its settings.
+ */
+func (e *configErrors) site(node *yaml.Node) *siteConfig {
+	site := new(siteConfig)
+	if node.Kind == yaml.ScalarNode {
+		if u := e.url(node); u != nil {
+			site.seeds = append(site.seeds, u)
+		}
+		return site
+	}
+	if node.Kind != yaml.MappingNode {
+		e.add(node, "expected a site URL or a mapping of site settings")
+		return nil
+	}
+
+	hasURL := false
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "url":
+			hasURL = true
+			if u := e.url(value); u != nil {
+				site.seeds = append([]*url.URL{u}, site.seeds...)
+			}
+		case "seeds":
+			if value.Kind != yaml.SequenceNode {
+				e.add(value, "seeds must be a list of URLs")
+				continue
+			}
+			for _, item := range value.Content {
+				if u := e.url(item); u != nil {
+					site.seeds = append(site.seeds, u)
+				}
+			}
+		case "scope":
+			site.scope = e.url(value)
+		case "delay":
+			if s, ok := e.expand(value); ok {
+				d, err := time.ParseDuration(s)
+				if err != nil || d < 0 {
+					e.add(value, "delay must be a duration such as 500ms, not %q", s)
+					continue
+				}
+				site.delay = &d
+			}
+		case "concurrency":
+			if s, ok := e.expand(value); ok {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 0 {
+					e.add(value, "concurrency must be a whole number of pages, not %q", s)
+					continue
+				}
+				site.concurrency = &n
+			}
+		default:
+			e.add(key, "unknown site setting %q", key.Value)
+		}
+	}
+
+	if !hasURL {
+		e.add(node, "site has no url")
+	}
+
+	return site
+}
+
+/*
+ * Load a config file, applying its settings to the flags in fs. Every
+ * problem in the file is reported in the error.
+ */
+func loadConfig(fs *flag.FlagSet, path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+
+	cfg := new(config)
+	if len(doc.Content) == 0 {
+		return cfg, nil
+	}
+
+	var errs configErrors
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		errs.add(root, "expected a mapping of settings")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		if key.Value == "sites" {
+			if value.Kind != yaml.SequenceNode {
+				errs.add(value, "sites must be a list of sites")
+				continue
+			}
+			for _, item := range value.Content {
+				if site := errs.site(item); site != nil {
+					cfg.sites = append(cfg.sites, site)
+				}
+			}
+			continue
+		}
+
+		f := fs.Lookup(key.Value)
+		if f == nil || key.Value == "config" {
+			errs.add(key, "unknown setting %q", key.Value)
+			continue
+		}
+
+		values := []*yaml.Node{value}
+		if _, ok := f.Value.(*stringList); ok && value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, v := range values {
+			s, ok := errs.expand(v)
+			if !ok {
+				continue
+			}
+			if err := fs.Set(key.Value, s); err != nil {
+				errs.add(v, "invalid value %q for %s", s, key.Value)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.New(path + ":\n  " + strings.Join(errs, "\n  "))
+	}
+
+	return cfg, nil
+}
+
+/*
+ * Return the seeds of every site in the config.
+ */
+func (cfg *config) seeds() []string {
+	var seeds []string
+	for _, site := range cfg.sites {
+		for _, seed := range site.seeds {
+			seeds = append(seeds, seed.String())
+		}
+	}
+
+	return seeds
+}
+
+/*
+ * Apply the settings of the configured site on the same host, if any.
+ */
+func (cfg *config) apply(site *crawler.Site) {
+	seed := site.Seeds[0]
+	for _, sc := range cfg.sites {
+		if len(sc.seeds) == 0 || sc.seeds[0].Scheme != seed.Scheme || sc.seeds[0].Host != seed.Host {
+			continue
+		}
+
+		if sc.scope != nil {
+			site.Scope = sc.scope
+		}
+		if sc.delay != nil {
+			site.Delay = *sc.delay
+		}
+		if sc.concurrency != nil {
+			site.Concurrency = *sc.concurrency
+		}
+	}
+}
+
+/*
+ * Check the flags for values which are invalid or do not make sense
+ * together.
+ */
+func checkFlags() error {
+	var problems []string
+	if state != "" && resume != "" {
+		problems = append(problems, "-state and -resume cannot be used together")
+	}
+	if _, err := cacheModeFlag(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := newLogger(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := clientOptions(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := loginForm(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := regexp.Compile(avoid); err != nil {
+		problems = append(problems, "invalid -avoid: "+err.Error())
+	}
+	if base, err := url.Parse(baseURL); err != nil || !base.IsAbs() {
+		problems = append(problems, "-base-url must be a fully formed URL")
+	}
+	if workers < 0 {
+		problems = append(problems, "-workers cannot be negative")
+	}
+	if delay < 0 {
+		problems = append(problems, "-delay cannot be negative")
+	}
+	if siteConcurrency < 0 {
+		problems = append(problems, "-site-concurrency cannot be negative")
+	}
+	if retries < 0 || retries429 < 0 {
+		problems = append(problems, "-retries and -retries-429 cannot be negative")
+	}
+	if retryDelay < 0 || retryMaxDelay < 0 {
+		problems = append(problems, "-retry-delay and -retry-max-delay cannot be negative")
+	}
+	if damping < 0 || damping > 1 {
+		problems = append(problems, "-damping must be between 0 and 1")
+	}
+	if similarity < 0 || similarity > 1 {
+		problems = append(problems, "-similarity must be between 0 and 1")
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
+
+// The flags whose values hold secrets, with the separator after the part
+// of each value which can be shown. The password of a URL is redacted
+// whatever its separator.
+var secretFlags = map[string]string{
+	"auth":        "=",
+	"bearer":      "=",
+	"header":      ":",
+	"login-field": "=",
+	"proxy":       "",
+}
+
+/*
+ * Return a flag's value to show, with any secrets in it redacted.
+ */
+func flagValue(f *flag.Flag) string {
+	sep, secret := secretFlags[f.Name]
+	if !secret {
+		return f.Value.String()
+	}
+	list, isList := f.Value.(*stringList)
+	if !isList {
+		if u, err := url.Parse(f.Value.String()); err == nil {
+			return u.Redacted()
+		}
+		return "(redacted)"
+	}
+
+	values := make([]string, len(*list))
+	for i, value := range *list {
+		name, _, _ := strings.Cut(value, sep)
+		values[i] = name + sep + "(redacted)"
+	}
+
+	return strings.Join(values, ",")
+}
+
+/*
+ * The config command. "config check file" loads the file and checks the
+ * settings in it, exiting with status 1 if there is a problem.
+ */
+func runConfig(fs *flag.FlagSet) int {
+	if fs.NArg() != 2 || fs.Arg(0) != "check" {
+		fs.Usage()
+		return 2
+	}
+
+	path := fs.Arg(1)
+	settings := allFlags()
+	file, err := loadConfig(settings, path)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	if err := checkFlags(); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	if err != nil {
+		return 1
+	}
+
+	fmt.Printf("%s is valid\n", path)
+	settings.VisitAll(func(f *flag.Flag) {
+		if f.Value.String() != f.DefValue {
+			fmt.Printf("  %s: %s\n", f.Name, flagValue(f))
+		}
+	})
+	for _, site := range file.sites {
+		fmt.Printf("  site %s\n", site.seeds[0])
+		for _, seed := range site.seeds[1:] {
+			fmt.Printf("    seed: %s\n", seed)
+		}
+		if site.scope != nil {
+			fmt.Printf("    scope: %s\n", site.scope)
+		}
+		if site.delay != nil {
+			fmt.Printf("    delay: %s\n", *site.delay)
+		}
+		if site.concurrency != nil {
+			fmt.Printf("    concurrency: %d\n", *site.concurrency)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"wapbot.co.uk/crawler"
+)
+
+/*
+ * Return the cache mode named by -cache-mode.
+ */
+func cacheModeFlag() (crawler.CacheMode, error) {
+	switch cacheMode {
+	case "fresh":
+		return crawler.CacheMode_IfFresh, nil
+	case "refresh":
+		return crawler.CacheMode_Refresh, nil
+	case "only":
+		return crawler.CacheMode_Only, nil
+	}
+
+	return crawler.CacheMode_IfFresh, fmt.Errorf("invalid -cache-mode: %s", cacheMode)
+}
+
+/*
+ * Return the HTTP client options set by the client flags.
+ */
+func clientOptions() (*crawler.ClientOptions, error) {
+	opts := &crawler.ClientOptions{
+		ConnectTimeout:     connectTimeout,
+		ReadTimeout:        readTimeout,
+		Timeout:            requestTimeout,
+		UserAgent:          userAgent,
+		Headers:            make(http.Header),
+		CAFile:             caFile,
+		InsecureSkipVerify: insecure,
+	}
+	if connectTimeout < 0 || readTimeout < 0 || requestTimeout < 0 {
+		return nil, errors.New("-connect-timeout, -read-timeout and -timeout cannot be negative")
+	}
+
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid -header: %s, expected \"Name: value\"", header)
+		}
+		opts.Headers.Add(name, strings.TrimSpace(value))
+	}
+
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid -proxy: %s", proxy)
+		}
+		opts.Proxy = u
+	}
+
+	opts.Credentials = make(map[string]*crawler.Credentials)
+	for _, auth := range basicAuth {
+		host, userPass, ok := strings.Cut(auth, "=")
+		user, pass, hasPass := strings.Cut(userPass, ":")
+		if !ok || host == "" || user == "" || !hasPass {
+			return nil, errors.New("invalid -auth, expected \"host=user:password\"")
+		}
+		opts.Credentials[host] = &crawler.Credentials{Username: user, Password: pass}
+	}
+	for _, bearer := range bearerAuth {
+		host, token, ok := strings.Cut(bearer, "=")
+		if !ok || host == "" || token == "" {
+			return nil, errors.New("invalid -bearer, expected \"host=token\"")
+		}
+		opts.Credentials[host] = &crawler.Credentials{Token: token}
+	}
+
+	return opts, nil
+}
+
+/*
+ * Return the login form set by -login-url and -login-field, or nil if
+ * there is no login step.
+ */
+func loginForm() (*crawler.LoginForm, error) {
+	if loginURL == "" {
+		if len(loginFields) > 0 {
+			return nil, errors.New("-login-field needs -login-url")
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(loginURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid -login-url: %s", loginURL)
+	}
+
+	form := &crawler.LoginForm{URL: loginURL, Fields: make(url.Values)}
+	for _, field := range loginFields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return nil, errors.New("invalid -login-field, expected \"name=value\"")
+		}
+		form.Fields.Add(name, value)
+	}
+
+	return form, nil
+}
+
+/*
+ * Return the links not to follow: those matching -avoid, or when crawling
+ * with a session or credentials, logout links.
+ */
+func avoidPattern() *regexp.Regexp {
+	if avoid != "" {
+		return regexp.MustCompile(avoid)
+	}
+	if cookies || loginURL != "" || len(basicAuth) > 0 || len(bearerAuth) > 0 {
+		return crawler.LogoutPattern
+	}
+
+	return nil
+}
+
+/*
+ * Return the retry policy set by the retry flags.
+ */
+func retryPolicy() *crawler.RetryPolicy {
+	policy := crawler.DefaultRetryPolicy
+	policy.MaxAttempts = map[crawler.FailureClass]int{
+		crawler.FailureClass_Timeout:         retries + 1,
+		crawler.FailureClass_Connection:      retries + 1,
+		crawler.FailureClass_ServerError:     retries + 1,
+		crawler.FailureClass_TooManyRequests: retries429 + 1,
+	}
+	policy.BaseDelay = retryDelay
+	policy.MaxDelay = retryMaxDelay
+
+	return &policy
+}
+
+/*
+ * A crawl set up from the crawl flags, ready to run.
+ */
+type crawlSession struct {
+	seeds   []*url.URL
+	opts    *crawler.Options
+	fetcher crawler.Fetcher
+	// Set if metrics are being served.
+	metrics *crawler.Metrics
+	// Called in reverse order by close.
+	closers []func()
+}
+
+/*
+ * Release everything the session opened.
+ */
+func (s *crawlSession) close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	s.closers = nil
+}
+
+/*
+ * Set up a crawl from the crawl flags. Problems are printed, and nil
+ * is returned if the crawl cannot be made.
+ */
+func newCrawlSession() *crawlSession {
+	s := &crawlSession{opts: &crawler.Options{Workers: workers, Logger: slog.Default(), Retry: retryPolicy()}}
+	ok := false
+	defer func() {
+		if !ok {
+			s.close()
+		}
+	}()
+
+	seeds := []string(sites)
+	if seedsFile != "" {
+		f, err := os.Open(seedsFile)
+		if err != nil {
+			slog.Error("unable to read seeds", "error", err)
+			return nil
+		}
+		listed, err := crawler.ReadURLList(f)
+		f.Close()
+		if err != nil {
+			slog.Error("unable to read seeds", "error", err)
+			return nil
+		}
+		seeds = append(seeds, listed...)
+	}
+	if len(sites) == 0 {
+		seeds = append(seeds, cfg.seeds()...)
+	}
+
+	if state != "" || resume != "" {
+		dir := state
+		if resume != "" {
+			dir = resume
+		}
+
+		store, err := crawler.OpenStore(dir)
+		if err != nil {
+			slog.Error("unable to open crawl state", "error", err)
+			return nil
+		}
+		s.closers = append(s.closers, func() { store.Close() })
+		s.opts.Store = store
+
+		seed, err := store.Seed()
+		if err != nil {
+			slog.Error("unable to read crawl state", "error", err)
+			return nil
+		}
+
+		if state != "" && seed != "" {
+			slog.Error("the state directory already holds a crawl, use -resume to continue it", "dir", dir, "seed", seed)
+			return nil
+		} else if resume != "" {
+			if seed == "" {
+				slog.Error("the state directory does not hold a crawl to resume", "dir", dir)
+				return nil
+			}
+			if len(seeds) == 0 {
+				seeds = append(seeds, seed)
+			}
+		}
+	}
+
+	var replayer *crawler.ReplayFetcher
+	if replay != "" {
+		var err error
+		replayer, err = crawler.NewReplayFetcher(replay)
+		if err != nil {
+			slog.Error("unable to read WARC archive", "error", err)
+			return nil
+		}
+		if len(seeds) == 0 {
+			seeds = append(seeds, replayer.First())
+		}
+	}
+
+	if len(seeds) == 0 {
+		slog.Error("-site flag is mandatory")
+		return nil
+	}
+
+	var fileSite *crawler.FileFetcher
+	if !strings.HasPrefix(seeds[0], "http://") &&
+		!strings.HasPrefix(seeds[0], "https://") {
+		if len(seeds) > 1 {
+			slog.Error("a -site directory cannot be crawled along with other sites")
+			return nil
+		}
+
+		base, _ := url.Parse(baseURL)
+
+		var seed *url.URL
+		var err error
+		fileSite, seed, err = crawler.NewFileSite(seeds[0], base)
+		if err != nil {
+			slog.Error("-site must be a fully formed URL, a file:// URL or a directory", "site", seeds[0])
+			return nil
+		}
+		seeds[0] = seed.String()
+	}
+
+	for _, seed := range seeds {
+		uri, err := url.Parse(seed)
+		if err != nil || !uri.IsAbs() {
+			slog.Error("invalid url", "url", seed)
+			return nil
+		}
+		s.seeds = append(s.seeds, uri)
+	}
+
+	var fetcher crawler.Fetcher
+	if replayer != nil {
+		fetcher = replayer
+	} else if fileSite != nil {
+		fetcher = fileSite
+	} else {
+		opts, _ := clientOptions()
+		form, _ := loginForm()
+		if cookies || form != nil {
+			opts.Jar, _ = cookiejar.New(nil)
+		}
+		client, err := crawler.NewClient(opts)
+		if err != nil {
+			slog.Error("unable to create HTTP client", "error", err)
+			return nil
+		}
+		if form != nil {
+			if err := crawler.Login(client, form); err != nil {
+				slog.Error("unable to log in", "error", err)
+				return nil
+			}
+			slog.Info("logged in", "url", form.URL)
+		}
+		fetcher = client
+	}
+	s.opts.Avoid = avoidPattern()
+	if replayer != nil || fileSite != nil {
+		// Recorded responses and files fail the same way every time.
+		s.opts.Retry = nil
+	}
+	if warc != "" {
+		w := crawler.NewWARCWriter(warc, warcMaxSize)
+		s.closers = append(s.closers, func() { w.Close() })
+		fetcher = &crawler.WARCFetcher{Fetcher: fetcher, Writer: w}
+	}
+
+	if cache != "" {
+		mode, _ := cacheModeFlag()
+		cf, err := crawler.NewCachingFetcher(cache, fetcher, mode)
+		if err != nil {
+			slog.Error("unable to open cache", "error", err)
+			return nil
+		}
+		cf.MaxAge = cacheMaxAge
+		fetcher = cf
+	} else if mirror != "" {
+		// Mirroring fetches everything again, so keep a temporary cache
+		// to save going back to the site.
+		tmp, err := os.MkdirTemp("", "crawlapp-cache")
+		if err != nil {
+			slog.Error("unable to create cache", "error", err)
+			return nil
+		}
+		s.closers = append(s.closers, func() { os.RemoveAll(tmp) })
+
+		cf, err := crawler.NewCachingFetcher(tmp, fetcher, crawler.CacheMode_IfFresh)
+		if err != nil {
+			slog.Error("unable to create cache", "error", err)
+			return nil
+		}
+		fetcher = cf
+	}
+	s.fetcher = fetcher
+	s.opts.Fetcher = fetcher
+
+	if previous != "" {
+		store, err := crawler.OpenStoreReadOnly(previous)
+		if err != nil {
+			slog.Error("unable to open previous crawl", "error", err)
+			return nil
+		}
+		s.closers = append(s.closers, func() { store.Close() })
+		s.opts.Previous = store
+	}
+
+	if metricsAddr != "" {
+		metrics, stop, err := serveMetrics(metricsAddr)
+		if err != nil {
+			slog.Error("unable to serve metrics", "error", err)
+			return nil
+		}
+		s.closers = append(s.closers, stop)
+		s.metrics = metrics
+	}
+
+	ok = true
+	return s
+}
+
+/*
+ * Crawl the sites of the seeds, returning the page of every seed which
+ * could be crawled. Returns false if no seed could be crawled.
+ */
+func (s *crawlSession) run() ([]*crawler.Page, bool) {
+	slog.Debug("starting crawl", "gomaxprocs", runtime.GOMAXPROCS(-1), "seeds", len(s.seeds))
+
+	groups := crawler.GroupSeeds(s.seeds)
+	for _, site := range groups {
+		site.Delay = delay
+		site.Concurrency = siteConcurrency
+		cfg.apply(site)
+	}
+
+	var hooks []*crawler.Hooks
+	if s.metrics != nil {
+		hooks = append(hooks, s.metrics.Hooks())
+	}
+	var p *progress
+	if showProgress {
+		p = newProgress(stderr)
+		hooks = append(hooks, p.hooks())
+		p.show()
+	}
+	if len(hooks) > 0 {
+		s.opts.Hooks = crawler.MultiHooks(hooks...)
+	}
+
+	results, err := crawler.ProcessSites(groups, s.opts)
+	if p != nil {
+		p.end()
+	}
+	if err != nil {
+		slog.Error("unable to crawl", "error", err)
+		return nil, false
+	}
+
+	var roots []*crawler.Page
+	for _, result := range results {
+		for i, page := range result.Pages {
+			if page != nil {
+				roots = append(roots, page)
+			} else {
+				slog.Error("unable to crawl", "url", result.Site.Seeds[i].String())
+			}
+		}
+		if result.Err != nil {
+			slog.Error("unable to crawl page", "error", result.Err)
+		}
+	}
+
+	return roots, len(roots) > 0
+}
+
+/*
+ * Create the -csv tables, if asked for.
+ */
+func createTables() (*crawler.TableWriter, bool) {
+	if tables == "" {
+		return nil, true
+	}
+
+	comma := ','
+	if tsv {
+		comma = '\t'
+	}
+
+	tw, err := crawler.CreateTables(tables, comma)
+	if err != nil {
+		slog.Error("unable to create tables", "error", err)
+		return nil, false
+	}
+
+	return tw, true
+}
+
+/*
+ * The crawl command crawls the sites given by the crawl flags.
+ */
+func runCrawl(fs *flag.FlagSet) int {
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s := newCrawlSession()
+	if s == nil {
+		return 1
+	}
+	defer s.close()
+
+	tw, ok := createTables()
+	if !ok {
+		return 1
+	}
+	if tw != nil {
+		defer tw.Close()
+		s.opts.OnPage = tw.WritePage
+	}
+
+	roots, ok := s.run()
+	if !ok {
+		return 1
+	}
+
+	for _, page := range roots {
+		crawler.Analyse(page, damping, iterations)
+		page.Dump()
+	}
+
+	if !writeCrawls(roots, s.fetcher, tw) {
+		return 1
+	}
+
+	if s.opts.Previous != nil {
+		records, err := s.opts.Previous.Pages()
+		if err != nil {
+			slog.Error("unable to read previous crawl", "error", err)
+			return 1
+		}
+
+		crawler.NewChangeReport(roots[0], records).Dump()
+	}
+
+	return 0
+}
+
+/*
+ * Write everything the output flags ask for about analysed crawls. The
+ * link and asset rows of the tables must already have been written.
+ * Returns false if anything could not be written.
+ */
+func writeCrawls(roots []*crawler.Page, fetcher crawler.Fetcher, tw *crawler.TableWriter) bool {
+	if tw != nil {
+		tw.WritePages(roots...)
+		if err := tw.Close(); err != nil {
+			slog.Error("unable to write tables", "error", err)
+			return false
+		}
+	}
+
+	for i, page := range roots {
+		name := func(path string) string {
+			return path
+		}
+		if len(roots) > 1 {
+			// Number the files written for each seed.
+			name = func(path string) string {
+				ext := filepath.Ext(path)
+				return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+			}
+		}
+
+		if !writeOutputs(page, fetcher, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+ * Write and print everything asked for about the crawl from one seed.
+ * Output files are named by passing their flag values through name.
+ * Returns false if anything could not be written.
+ */
+func writeOutputs(page *crawler.Page, fetcher crawler.Fetcher, name func(string) string) bool {
+	if save != "" {
+		if err := writeFile(name(save), page, crawler.WriteJSON); err != nil {
+			slog.Error("unable to save crawl", "error", err)
+			return false
+		}
+	}
+
+	exports := []struct {
+		path  string
+		what  string
+		write func(io.Writer, *crawler.Page) error
+	}{
+		{report, "report", crawler.WriteReport},
+		{graphml, "GraphML", crawler.WriteGraphML},
+		{gexf, "GEXF", crawler.WriteGEXF},
+	}
+	for _, export := range exports {
+		if export.path == "" {
+			continue
+		}
+		if err := writeFile(name(export.path), page, export.write); err != nil {
+			slog.Error("unable to write "+export.what, "path", name(export.path), "error", err)
+			return false
+		}
+	}
+
+	if mirror != "" {
+		// Each site is mirrored under its own host directory.
+		m := crawler.NewMirror(mirror, fetcher)
+		if err := m.Save(page); err != nil {
+			slog.Error("unable to mirror site", "error", err)
+			return false
+		}
+		for uri, reason := range m.Skipped {
+			slog.Warn("unable to mirror", "url", uri, "reason", reason)
+		}
+	}
+
+	if sitemap != "" || known != "" {
+		var uris []string
+		if sitemap != "" {
+			listed, err := crawler.FetchSitemap(fetcher, sitemap)
+			if err != nil {
+				slog.Error("unable to read sitemap", "error", err)
+				return false
+			}
+			uris = append(uris, listed...)
+		}
+		if known != "" {
+			f, err := os.Open(known)
+			if err != nil {
+				slog.Error("unable to read known pages", "error", err)
+				return false
+			}
+			listed, err := crawler.ReadURLList(f)
+			f.Close()
+			if err != nil {
+				slog.Error("unable to read known pages", "error", err)
+				return false
+			}
+			uris = append(uris, listed...)
+		}
+
+		crawler.NewOrphanReport(page, uris).Dump()
+	}
+
+	if duplicates || duplicatesJSON != "" {
+		dups := crawler.NewDuplicateReport(page, similarity)
+		if duplicates {
+			dups.Dump()
+		}
+		if duplicatesJSON != "" {
+			f, err := os.Create(name(duplicatesJSON))
+			if err != nil {
+				slog.Error("unable to write duplicates", "error", err)
+				return false
+			}
+			defer f.Close()
+
+			if err := dups.WriteJSON(f); err != nil {
+				slog.Error("unable to write duplicates", "error", err)
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+/*
+ * Write the crawl to a new file.
+ */
+func writeFile(path string, page *crawler.Page, write func(io.Writer, *crawler.Page) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f, page); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"wapbot.co.uk/crawler"
+)
+
+func Test_AvoidPattern(t *testing.T) {
+	Convey("Given the session and avoid flags", t, func() {
+		avoid, cookies, loginURL, basicAuth, bearerAuth = "", false, "", nil, nil
+		Reset(func() {
+			avoid, cookies, loginURL, basicAuth, bearerAuth = "", false, "", nil, nil
+		})
+
+		Convey("Check that an unauthenticated crawl follows every link", func() {
+			So(avoidPattern(), ShouldBeNil)
+		})
+
+		Convey("Check that a crawl with a session avoids logout links only", func() {
+			sessions := []func(){
+				func() { cookies = true },
+				func() { loginURL = "http://one.link/login" },
+				func() { basicAuth = stringList{"one.link=me:secret"} },
+				func() { bearerAuth = stringList{"one.link=token"} },
+			}
+			for _, session := range sessions {
+				avoid, cookies, loginURL, basicAuth, bearerAuth = "", false, "", nil, nil
+				session()
+				So(avoidPattern(), ShouldEqual, crawler.LogoutPattern)
+			}
+			So(avoidPattern().MatchString("/account/logout"), ShouldBeTrue)
+			So(avoidPattern().MatchString("/catalog-outlet"), ShouldBeFalse)
+		})
+
+		Convey("Check that -avoid replaces the default", func() {
+			avoid, cookies = "^/private/", true
+			So(avoidPattern().String(), ShouldEqual, "^/private/")
+		})
+	})
+}
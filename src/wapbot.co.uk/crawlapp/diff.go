@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"wapbot.co.uk/crawler"
+)
+
+/*
+ * Load a saved crawl. The path is either a directory holding a crawl
+ * checkpointed with -state, or a JSON file written with -save.
+ */
+func loadCrawl(path string) (*crawler.Page, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		store, err := crawler.OpenStoreReadOnly(path)
+		if err != nil {
+			return nil, err
+		}
+		defer store.Close()
+
+		return store.Root()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return crawler.ReadJSON(f)
+}
+
+var diffJSON bool
+
+func diffFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&diffJSON, "json", false, "print the changes as JSON")
+}
+
+/*
+ * The diff command compares two saved crawls.
+ */
+func runDiff(fs *flag.FlagSet) int {
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	oldRoot, err := loadCrawl(fs.Arg(0))
+	if err != nil {
+		slog.Error("unable to load crawl", "path", fs.Arg(0), "error", err)
+		return 1
+	}
+
+	newRoot, err := loadCrawl(fs.Arg(1))
+	if err != nil {
+		slog.Error("unable to load crawl", "path", fs.Arg(1), "error", err)
+		return 1
+	}
+
+	diff := crawler.NewDiff(oldRoot, newRoot)
+	if diffJSON {
+		if err := diff.WriteJSON(os.Stdout); err != nil {
+			slog.Error("unable to write diff", "error", err)
+			return 1
+		}
+	} else {
+		diff.Dump()
+	}
+
+	return 0
+}
@@ -4,54 +4,366 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/url"
+	"log/slog"
 	"os"
-	"runtime"
 	"runtime/pprof"
 	"strings"
+	"time"
 	"wapbot.co.uk/crawler"
 )
 
-var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
-var site = flag.String("site", "", "site to process")
+// Flags shared by every command.
+var configFile string
+var cpuprofile string
+var logLevel string
+var logFormat string
 
-func main() {
-	flag.Parse()
+// Flags of the commands which crawl.
+var sites stringList
+var seedsFile string
+var workers int
+var delay time.Duration
+var siteConcurrency int
+var state string
+var resume string
+var previous string
+var cache string
+var cacheMode string
+var cacheMaxAge time.Duration
+var warc string
+var warcMaxSize int64
+var mirror string
+var baseURL string
+var replay string
+var retries int
+var retries429 int
+var retryDelay time.Duration
+var retryMaxDelay time.Duration
+var connectTimeout time.Duration
+var readTimeout time.Duration
+var requestTimeout time.Duration
+var userAgent string
+var headers stringList
+var proxy string
+var caFile string
+var insecure bool
+var cookies bool
+var basicAuth stringList
+var bearerAuth stringList
+var loginURL string
+var loginFields stringList
+var avoid string
+var showProgress bool
 
-	if *site == "" {
-		fmt.Println("-site flag is mandatory")
-		return
+// Flags of the commands which write out crawls.
+var save string
+var report string
+var graphml string
+var gexf string
+var tables string
+var tsv bool
+var damping float64
+var iterations int
+var sitemap string
+var known string
+var duplicates bool
+var duplicatesJSON string
+var similarity float64
+
+// The per-site settings from the config file.
+var cfg = new(config)
+
+/*
+ * The values of a flag which may be given more than once.
+ */
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+/*
+ * Register the flags shared by every command.
+ */
+func commonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFile, "config", "", "read settings from this YAML file; flags override its settings")
+	fs.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
+	fs.StringVar(&logLevel, "log-level", "warn", "log messages of this level and above to stderr: debug, info, warn or error")
+	fs.StringVar(&logFormat, "log-format", "text", "the format of log messages: text or json")
+}
+
+/*
+ * Return the logger asked for by -log-level and -log-format.
+ */
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid -log-level: %s", logLevel)
 	}
 
-	if !strings.HasPrefix(*site, "http://") &&
-		!strings.HasPrefix(*site, "https://") {
-		fmt.Println("-site must be a fully formed URL")
-		return
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		return slog.New(slog.NewTextHandler(stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(stderr, opts)), nil
 	}
 
-	fmt.Printf("GOMAXPROCS is set to: %d\n", runtime.GOMAXPROCS(-1))
+	return nil, fmt.Errorf("invalid -log-format: %s", logFormat)
+}
 
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
+/*
+ * Register the flags which control how sites are crawled.
+ */
+func crawlFlags(fs *flag.FlagSet) {
+	fs.Var(&sites, "site", "site to process (may be repeated)")
+	fs.StringVar(&seedsFile, "seeds", "", "also crawl the URLs listed in this file, one per line")
+	fs.IntVar(&workers, "workers", 0, "the most pages fetched at once across every site (0 means no limit)")
+	fs.DurationVar(&delay, "delay", 0, "the time to leave between starting fetches from each site")
+	fs.IntVar(&siteConcurrency, "site-concurrency", 0, "the most pages fetched at once from each site (0 means no limit)")
+	clientFlags(fs)
+	fs.BoolVar(&cookies, "cookies", false, "keep the cookies sites set and send them back for the rest of the crawl")
+	fs.StringVar(&loginURL, "login-url", "", "log in with the form on this page before crawling, keeping the session cookies")
+	fs.Var(&loginFields, "login-field", "a field to submit to the -login-url form, as \"name=value\" (may be repeated)")
+	fs.StringVar(&avoid, "avoid", "", "do not follow links whose path and query match this regular expression (defaults to logout links when crawling with a session or credentials)")
+	fs.StringVar(&state, "state", "", "checkpoint the crawl to this directory")
+	fs.StringVar(&resume, "resume", "", "resume the crawl checkpointed in this directory")
+	fs.StringVar(&previous, "previous", "", "re-crawl incrementally against the crawl checkpointed in this directory")
+	fs.StringVar(&cache, "cache", "", "cache responses in this directory")
+	fs.StringVar(&cacheMode, "cache-mode", "fresh", "how to use the cache: fresh, refresh or only")
+	fs.DurationVar(&cacheMaxAge, "cache-max-age", 0, "how long cached responses stay fresh (0 means forever)")
+	fs.StringVar(&warc, "warc", "", "record every fetch to WARC files named after this path (add .gz to compress)")
+	fs.Int64Var(&warcMaxSize, "warc-max-size", 1<<30, "start a new WARC file once one reaches this many bytes")
+	fs.StringVar(&mirror, "mirror", "", "save the crawled pages and assets to this directory for offline browsing")
+	fs.StringVar(&baseURL, "base-url", "http://localhost/", "the URL a -site directory is served at")
+	fs.StringVar(&replay, "replay", "", "crawl the responses recorded in this WARC file or directory of WARC files instead of the live site")
+	metricsFlag(fs)
+	fs.BoolVar(&showProgress, "progress", isTerminal(os.Stderr), "show the progress of the crawl on stderr (the default when stderr is a terminal)")
+}
+
+/*
+ * Register the flags which control how pages are requested, shared by the
+ * commands which crawl and by serve.
+ */
+func clientFlags(fs *flag.FlagSet) {
+	fs.IntVar(&retries, "retries", 2, "the most times a page is fetched again after a timeout, connection failure or 5xx response")
+	fs.IntVar(&retries429, "retries-429", 4, "the most times a page is fetched again after a 429 response")
+	fs.DurationVar(&retryDelay, "retry-delay", crawler.DefaultRetryPolicy.BaseDelay, "the time to wait before the first retry of a page, doubled for each retry after it")
+	fs.DurationVar(&retryMaxDelay, "retry-max-delay", crawler.DefaultRetryPolicy.MaxDelay, "the longest time to wait before retrying a page")
+	fs.DurationVar(&connectTimeout, "connect-timeout", crawler.DefaultClientOptions.ConnectTimeout, "the longest time to wait to connect to a server (0 means no limit)")
+	fs.DurationVar(&readTimeout, "read-timeout", crawler.DefaultClientOptions.ReadTimeout, "the longest time to wait for a response once a request is sent (0 means no limit)")
+	fs.DurationVar(&requestTimeout, "timeout", crawler.DefaultClientOptions.Timeout, "the longest time a request may take, including reading the page (0 means no limit)")
+	fs.StringVar(&userAgent, "user-agent", crawler.DefaultUserAgent, "the User-Agent to send")
+	fs.Var(&headers, "header", "a header to send with every request, as \"Name: value\" (may be repeated)")
+	fs.StringVar(&proxy, "proxy", "", "make requests through this http, https or socks5 proxy URL (defaults to the HTTP_PROXY environment variables)")
+	fs.StringVar(&caFile, "ca-file", "", "trust the PEM encoded certificates in this file as well as the system's")
+	fs.BoolVar(&insecure, "insecure", false, "do not verify the certificates of servers")
+	fs.Var(&basicAuth, "auth", "basic auth credentials for a host, as \"host=user:password\" (may be repeated)")
+	fs.Var(&bearerAuth, "bearer", "a bearer token for a host, as \"host=token\" (may be repeated)")
+}
+
+/*
+ * Register the flags which control what is written about a crawl.
+ */
+func outputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&save, "save", "", "save the crawled pages to this JSON file")
+	fs.StringVar(&report, "report", "", "write a self-contained HTML report of the crawl to this file")
+	fs.StringVar(&graphml, "graphml", "", "write the site network to this GraphML file")
+	fs.StringVar(&gexf, "gexf", "", "write the site network to this GEXF file")
+	fs.StringVar(&tables, "csv", "", "write pages, links and assets tables to this directory")
+	fs.BoolVar(&tsv, "tsv", false, "write the -csv tables tab separated")
+	fs.Float64Var(&damping, "damping", crawler.DefaultDamping, "the PageRank damping factor")
+	fs.IntVar(&iterations, "iterations", crawler.DefaultIterations, "the number of PageRank iterations")
+	fs.StringVar(&sitemap, "sitemap", "", "report orphan pages against the pages listed in the sitemap at this URL")
+	fs.StringVar(&known, "known", "", "report orphan pages against the URLs listed in this file, one per line")
+	fs.BoolVar(&duplicates, "duplicates", false, "print clusters of pages with duplicate or near duplicate text")
+	fs.StringVar(&duplicatesJSON, "duplicates-json", "", "write the clusters of duplicate pages to this JSON file")
+	fs.Float64Var(&similarity, "similarity", crawler.DefaultSimilarity, "how similar, from 0 to 1, the text of near duplicate pages is")
+}
+
+/*
+ * Return a flag set holding every setting a config file may hold.
+ * Registering the flags resets them to their defaults.
+ */
+func allFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	commonFlags(fs)
+	crawlFlags(fs)
+	outputFlags(fs)
+
+	return fs
+}
+
+/*
+ * A crawlapp subcommand. Run is called with the parsed flags and returns
+ * the exit status.
+ */
+type command struct {
+	name  string
+	args  string
+	about string
+	flags func(*flag.FlagSet)
+	run   func(*flag.FlagSet) int
+}
+
+var commands = []*command{
+	{"crawl", "[flags]",
+		"Crawl sites, printing the pages found and writing whatever the output flags ask for.",
+		func(fs *flag.FlagSet) {
+			crawlFlags(fs)
+			outputFlags(fs)
+		}, runCrawl},
+	{"report", "[flags] saved_crawl...",
+		"Render crawls saved with -save or -state without fetching them again. Each crawl is printed\n" +
+			"in -format, and the output flags write it out in other forms as well.",
+		reportFlags, runReport},
+	{"check-links", "[flags] [saved_crawl...]",
+		"Check for links to broken pages, in the saved crawls if given and by crawling otherwise.\n" +
+			"Exits with status 1 if there are broken links and 2 if the check could not be made.",
+		crawlFlags, runCheckLinks},
+	{"diff", "[flags] old_crawl new_crawl",
+		"Compare two crawls saved with -save or -state.",
+		diffFlags, runDiff},
+	{"serve", "[flags] [saved_crawl]",
+		"Serve a REST API for running crawl jobs at /jobs, and the HTML report of a saved crawl at /\n" +
+			"along with its JSON, GraphML and GEXF forms.",
+		serveFlags, runServe},
+	{"config", "check config_file",
+		"Check a config file, printing the settings in effect or every problem found.",
+		func(fs *flag.FlagSet) {}, runConfig},
+}
+
+/*
+ * Print the commands.
+ */
+func usage() {
+	out := os.Stderr
+	fmt.Fprintf(out, "Usage: %s command [flags] [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		about := cmd.about
+		if i := strings.IndexAny(about, ",.\n"); i >= 0 {
+			about = about[:i]
 		}
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, about)
+	}
+	fmt.Fprintf(out, "\nRun \"%s help command\" for the flags of a command.\n", os.Args[0])
+}
 
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+/*
+ * Create the flag set of a command.
+ */
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	commonFlags(fs)
+	cmd.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n\nFlags:\n", os.Args[0], cmd.name, cmd.args, cmd.about)
+		fs.PrintDefaults()
 	}
 
-	uri, err := url.Parse(*site)
-	if err != nil {
-		fmt.Printf("Invalid url: %s\n", err.Error())
-		return
+	return fs
+}
+
+/*
+ * Parse a command's flags, applying the -config file first if one is
+ * given so that the command line overrides it.
+ */
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	settings := allFlags()
+	fs.Parse(args)
+	if configFile == "" {
+		return nil
 	}
 
-	page, err := crawler.ProcessPage(uri)
+	var err error
+	cfg, err = loadConfig(settings, configFile)
 	if err != nil {
-		fmt.Printf("Unable to crawl page: %s\n", err.Error())
+		return err
+	}
+
+	// Parse the command line again so that its flags override the file.
+	// Lists such as sites and headers given on the command line replace
+	// those in the file.
+	fs.Visit(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			*list = nil
+		}
+	})
+	fs.Parse(args)
+
+	return nil
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := args[0]
+	switch {
+	case name == "-h" || name == "-help" || name == "--help":
+		name = "help"
+	case strings.HasPrefix(name, "-"):
+		// Flags without a command crawl, as crawlapp always has.
+		name = "crawl"
+	default:
+		args = args[1:]
+	}
+
+	if name == "help" {
+		for _, cmd := range commands {
+			if len(args) > 0 && args[0] == cmd.name {
+				newFlagSet(cmd).Usage()
+				return
+			}
+		}
+		usage()
 		return
 	}
 
-	page.Dump()
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	fs := newFlagSet(cmd)
+	if err := parseFlags(fs, args); err != nil {
+		// The logger is not set up until the flags are known to be valid.
+		fmt.Fprintf(os.Stderr, "Unable to read config: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	if err := checkFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	logger, _ := newLogger()
+	slog.SetDefault(logger)
+
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		pprof.StartCPUProfile(f)
+	}
+
+	status := cmd.run(fs)
+	pprof.StopCPUProfile()
+	os.Exit(status)
 }
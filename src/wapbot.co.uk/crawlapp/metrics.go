@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"wapbot.co.uk/crawler"
+)
+
+var metricsAddr string
+
+func metricsFlag(fs *flag.FlagSet) {
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics at /metrics on this address")
+}
+
+/*
+ * Serve new metrics at /metrics on addr in the background. The returned
+ * function stops serving them.
+ */
+func serveMetrics(addr string) (*crawler.Metrics, func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metrics := crawler.NewMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+
+	return metrics, func() { server.Close() }, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wapbot.co.uk/crawler"
+)
+
+/*
+ * Counts what a crawl has done through its hooks and shows it on a line
+ * which is redrawn as the crawl runs.
+ */
+type progress struct {
+	start   time.Time
+	fetched int64
+	queued  int64
+	errors  int64
+	bytes   int64
+
+	out  *statusWriter
+	stop chan struct{}
+	done chan struct{}
+}
+
+/*
+ * Report whether f is a terminal, which the progress line is shown on by
+ * default.
+ */
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+/*
+ * Writes to a terminal on which a status line may be shown. The status
+ * line is cleared before anything else is written, so that it is not
+ * written over, and is drawn again when it is next updated.
+ */
+type statusWriter struct {
+	sync.Mutex
+	out io.Writer
+	// Whether the status line is shown.
+	shown bool
+}
+
+// Standard error, which the progress line and logs are written to.
+var stderr = &statusWriter{out: os.Stderr}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.Lock()
+	defer sw.Unlock()
+	if sw.shown {
+		io.WriteString(sw.out, "\r\033[K")
+		sw.shown = false
+	}
+
+	return sw.out.Write(p)
+}
+
+/*
+ * Replace the status line. If done is set then the line is left in place
+ * and the status line ends.
+ */
+func (sw *statusWriter) status(line string, done bool) {
+	sw.Lock()
+	defer sw.Unlock()
+	if done {
+		fmt.Fprintf(sw.out, "\r%s\033[K\n", line)
+	} else {
+		fmt.Fprintf(sw.out, "\r%s\033[K", line)
+	}
+	sw.shown = !done
+}
+
+func newProgress(out *statusWriter) *progress {
+	return &progress{start: time.Now(), out: out, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+/*
+ * Return the hooks which count the crawl's progress.
+ */
+func (p *progress) hooks() *crawler.Hooks {
+	return &crawler.Hooks{
+		OnLinkDiscovered: func(from *crawler.Page, uri string) {
+			atomic.AddInt64(&p.queued, 1)
+		},
+		OnFetchStart: func(uri string) {
+			atomic.AddInt64(&p.queued, -1)
+		},
+		OnFetched: func(e *crawler.FetchEvent) {
+			if e.Err == nil {
+				atomic.AddInt64(&p.fetched, 1)
+			}
+			atomic.AddInt64(&p.bytes, e.Bytes)
+		},
+		OnError: func(uri string, err error) {
+			atomic.AddInt64(&p.errors, 1)
+		},
+		OnSkipped: func(uri string, reason string) {
+			if crawler.Unfetched(reason) {
+				atomic.AddInt64(&p.queued, -1)
+			}
+		},
+	}
+}
+
+/*
+ * Format a number of bytes for people to read.
+ */
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n) / unit
+	prefix := 0
+	for value >= unit && prefix < 3 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[prefix])
+}
+
+/*
+ * Describe the progress so far.
+ */
+func (p *progress) line() string {
+	fetched := atomic.LoadInt64(&p.fetched)
+	rate := float64(fetched) / time.Since(p.start).Seconds()
+	return fmt.Sprintf("%d pages, %.1f pages/s, %d queued, %d errors, %s",
+		fetched, rate, atomic.LoadInt64(&p.queued), atomic.LoadInt64(&p.errors),
+		formatBytes(atomic.LoadInt64(&p.bytes)))
+}
+
+/*
+ * Redraw the progress line until end is called.
+ */
+func (p *progress) show() {
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.out.status(p.line(), false)
+			case <-p.stop:
+				p.out.status(p.line(), true)
+				return
+			}
+		}
+	}()
+}
+
+/*
+ * Stop redrawing the progress line, leaving the final progress shown.
+ */
+func (p *progress) end() {
+	close(p.stop)
+	<-p.done
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"wapbot.co.uk/crawler"
+)
+
+var format string
+
+/*
+ * Write a crawl as its text dump.
+ */
+func writeDump(w io.Writer, root *crawler.Page) error {
+	var buf bytes.Buffer
+	root.DumpToBuffer(&buf)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+/*
+ * The formats a crawl can be rendered in, by name.
+ */
+var formats = map[string]func(io.Writer, *crawler.Page) error{
+	"text":    writeDump,
+	"json":    crawler.WriteJSON,
+	"html":    crawler.WriteReport,
+	"graphml": crawler.WriteGraphML,
+	"gexf":    crawler.WriteGEXF,
+}
+
+func reportFlags(fs *flag.FlagSet) {
+	outputFlags(fs)
+	fs.StringVar(&format, "format", "text", "print each crawl as text, json, html, graphml or gexf, or none to only write the output flags")
+}
+
+/*
+ * The report command renders saved crawls.
+ */
+func runReport(fs *flag.FlagSet) int {
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	write, exists := formats[format]
+	if !exists && format != "none" {
+		slog.Error("invalid -format", "format", format)
+		return 2
+	}
+
+	var roots []*crawler.Page
+	for _, path := range fs.Args() {
+		root, err := loadCrawl(path)
+		if err != nil {
+			slog.Error("unable to load crawl", "path", path, "error", err)
+			return 1
+		}
+		crawler.Analyse(root, damping, iterations)
+		roots = append(roots, root)
+	}
+
+	tw, ok := createTables()
+	if !ok {
+		return 1
+	}
+	if tw != nil {
+		defer tw.Close()
+		for _, root := range roots {
+			root.Walk(tw.WritePage)
+		}
+	}
+
+	if write != nil {
+		for _, root := range roots {
+			if err := write(os.Stdout, root); err != nil {
+				slog.Error("unable to write crawl", "error", err)
+				return 1
+			}
+		}
+	}
+
+	client, _ := crawler.NewClient(nil)
+	if !writeCrawls(roots, client, tw) {
+		return 1
+	}
+
+	return 0
+}
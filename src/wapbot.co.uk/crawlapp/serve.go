@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"wapbot.co.uk/crawler"
+)
+
+var addr string
+var jobsDir string
+var queueSize int
+var jobWorkers int
+
+func serveFlags(fs *flag.FlagSet) {
+	fs.StringVar(&addr, "addr", "localhost:8080", "the address to listen on")
+	fs.StringVar(&jobsDir, "jobs", "crawlapp-jobs", "keep the jobs run through the API and their results in this directory")
+	fs.IntVar(&queueSize, "queue-size", 16, "the most jobs waiting to run")
+	fs.IntVar(&jobWorkers, "job-workers", 2, "the most jobs run at once")
+	fs.Float64Var(&damping, "damping", crawler.DefaultDamping, "the PageRank damping factor")
+	fs.IntVar(&iterations, "iterations", crawler.DefaultIterations, "the number of PageRank iterations")
+	clientFlags(fs)
+	metricsFlag(fs)
+}
+
+/*
+ * Return a handler which renders the crawl with write.
+ */
+func crawlHandler(root *crawler.Page, contentType string, write func(io.Writer, *crawler.Page) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := write(&buf, root); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		buf.WriteTo(w)
+	}
+}
+
+/*
+ * The serve command serves the job API, and a saved crawl if one is
+ * given, over HTTP.
+ */
+func runServe(fs *flag.FlagSet) int {
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	}
+
+	opts, _ := clientOptions()
+	client, err := crawler.NewClient(opts)
+	if err != nil {
+		slog.Error("unable to create HTTP client", "error", err)
+		return 1
+	}
+
+	queue, err := crawler.NewJobQueue(jobsDir, queueSize, jobWorkers, client)
+	if err != nil {
+		slog.Error("unable to open job queue", "error", err)
+		return 1
+	}
+	defer queue.Close()
+	queue.Logger = slog.Default()
+	queue.Retry = retryPolicy()
+
+	if metricsAddr != "" {
+		metrics, stop, err := serveMetrics(metricsAddr)
+		if err != nil {
+			slog.Error("unable to serve metrics", "error", err)
+			return 1
+		}
+		defer stop()
+		queue.Hooks = metrics.Hooks()
+		fmt.Printf("Serving metrics on http://%s/metrics\n", metricsAddr)
+	}
+
+	mux := http.NewServeMux()
+	api := crawler.NewJobHandler(queue)
+	mux.Handle("/jobs", api)
+	mux.Handle("/jobs/", api)
+
+	if fs.NArg() == 1 {
+		root, err := loadCrawl(fs.Arg(0))
+		if err != nil {
+			slog.Error("unable to load crawl", "path", fs.Arg(0), "error", err)
+			return 1
+		}
+		crawler.Analyse(root, damping, iterations)
+		serveCrawl(mux, root)
+		fmt.Printf("Serving %s on http://%s/\n", fs.Arg(0), addr)
+	}
+
+	fmt.Printf("Serving the job API on http://%s/jobs\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("unable to serve", "error", err)
+		return 1
+	}
+
+	return 0
+}
+
+/*
+ * Serve the report of a crawl at / and its other forms beside it.
+ */
+func serveCrawl(mux *http.ServeMux, root *crawler.Page) {
+	report := crawlHandler(root, "text/html; charset=utf-8", crawler.WriteReport)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		report(w, r)
+	})
+	mux.Handle("/crawl.json", crawlHandler(root, "application/json", crawler.WriteJSON))
+	mux.Handle("/crawl.graphml", crawlHandler(root, "application/xml", crawler.WriteGraphML))
+	mux.Handle("/crawl.gexf", crawlHandler(root, "application/xml", crawler.WriteGEXF))
+}
@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"sort"
+)
+
+// The damping factor and iteration count usually used for PageRank.
+const (
+	DefaultDamping    = 0.85
+	DefaultIterations = 50
+)
+
+/**
+ * Work out how the internal linking of the pages reachable from root
+ * distributes link equity. Each page has its click depth, in and out
+ * degree, and PageRank over the local links set. The PageRanks of the
+ * pages add up to one.
+ *
+ * damping is the probability of following a link rather than jumping to
+ * a random page, and iterations is the number of rounds of the power
+ * method run. Pages with no outbound links share their rank among every
+ * page.
+ */
+func Analyse(root *Page, damping float64, iterations int) {
+	var pages []*Page
+	root.Walk(func(p *Page) {
+		pages = append(pages, p)
+	})
+	// Sum in a fixed order so that the result does not vary between runs.
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URI < pages[j].URI
+	})
+
+	index := make(map[*Page]int)
+	for i, p := range pages {
+		index[p] = i
+		p.InDegree = 0
+	}
+
+	depths := root.Depths()
+	for _, p := range pages {
+		p.Depth = depths[p]
+		p.OutDegree = len(p.Pages)
+		for _, np := range p.Pages {
+			np.InDegree++
+		}
+	}
+
+	n := float64(len(pages))
+	rank := make([]float64, len(pages))
+	for i := range rank {
+		rank[i] = 1 / n
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		dangling := 0.0
+		for i, p := range pages {
+			if len(p.Pages) == 0 {
+				dangling += rank[i]
+			}
+		}
+
+		next := make([]float64, len(pages))
+		for i := range next {
+			next[i] = (1-damping)/n + damping*dangling/n
+		}
+		for i, p := range pages {
+			if len(p.Pages) == 0 {
+				continue
+			}
+			share := damping * rank[i] / float64(len(p.Pages))
+			for _, np := range p.Pages {
+				next[index[np]] += share
+			}
+		}
+		rank = next
+	}
+
+	for i, p := range pages {
+		p.PageRank = rank[i]
+	}
+}
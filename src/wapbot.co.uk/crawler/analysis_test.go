@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Analyse(t *testing.T) {
+	Convey("Given three linked pages", t, func() {
+		a := NewPage("http://local.link/", "A")
+		b := NewPage("http://local.link/b", "B")
+		c := NewPage("http://local.link/c", "C")
+		a.AddPage(b)
+		a.AddPage(c)
+		b.AddPage(c)
+		c.AddPage(a)
+
+		Convey("Check the PageRank, degrees and depths", func() {
+			Analyse(a, DefaultDamping, DefaultIterations)
+			So(a.PageRank, ShouldAlmostEqual, 0.3878, 0.0001)
+			So(b.PageRank, ShouldAlmostEqual, 0.2148, 0.0001)
+			So(c.PageRank, ShouldAlmostEqual, 0.3974, 0.0001)
+
+			So(a.InDegree, ShouldEqual, 1)
+			So(a.OutDegree, ShouldEqual, 2)
+			So(c.InDegree, ShouldEqual, 2)
+			So(c.OutDegree, ShouldEqual, 1)
+			So(a.Depth, ShouldEqual, 0)
+			So(c.Depth, ShouldEqual, 1)
+		})
+
+		Convey("Check that analysing again gives the same result", func() {
+			Analyse(a, DefaultDamping, DefaultIterations)
+			Analyse(a, DefaultDamping, DefaultIterations)
+			So(c.InDegree, ShouldEqual, 2)
+			So(a.PageRank, ShouldAlmostEqual, 0.3878, 0.0001)
+		})
+
+		Convey("Check that without damping a single iteration follows the links", func() {
+			Analyse(a, 1, 1)
+			So(a.PageRank, ShouldAlmostEqual, 1.0/3, 0.0001)
+			So(b.PageRank, ShouldAlmostEqual, 1.0/6, 0.0001)
+			So(c.PageRank, ShouldAlmostEqual, 1.0/2, 0.0001)
+		})
+
+		Convey("Check that the scores are dumped and saved", func() {
+			Analyse(a, DefaultDamping, DefaultIterations)
+
+			var buf bytes.Buffer
+			b.DumpToBuffer(&buf)
+			So(buf.String(), ShouldStartWith, "Title: B\nURI:   http://local.link/b\nRank:  0.2148 (depth 1, 1 in, 1 out)\n")
+
+			rec := NewPageRecord(c, nil)
+			So(rec.Depth, ShouldEqual, 1)
+			So(rec.InDegree, ShouldEqual, 2)
+			So(rec.OutDegree, ShouldEqual, 1)
+			So(rec.Page().PageRank, ShouldEqual, c.PageRank)
+		})
+	})
+
+	Convey("Given a page with no links out", t, func() {
+		a := NewPage("http://local.link/", "A")
+		b := NewPage("http://local.link/b", "B")
+		a.AddPage(b)
+
+		Convey("Check that its rank is shared and the ranks add up to one", func() {
+			Analyse(a, DefaultDamping, DefaultIterations)
+			So(a.PageRank+b.PageRank, ShouldAlmostEqual, 1, 0.0001)
+			So(b.PageRank, ShouldBeGreaterThan, a.PageRank)
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Returned for unknown result formats and tables.
+var errBadFormat = errors.New("format must be json, csv or dot")
+var errBadTable = errors.New("table must be pages, links or assets")
+
+/*
+ * Serves the job API of a queue.
+ */
+type jobHandler struct {
+	queue *JobQueue
+}
+
+/**
+ * Return a handler for a REST API to a job queue:
+ *
+ *	POST   /jobs               queue a job from a JSON JobRequest
+ *	GET    /jobs               list the jobs
+ *	GET    /jobs/ID            the state and progress of a job
+ *	GET    /jobs/ID/results    the crawl made by a finished job
+ *	DELETE /jobs/ID            cancel a job
+ *
+ * The results are JSON by default. ?format=csv gives the pages table,
+ * or the links or assets table with &table=links or &table=assets, and
+ * ?format=dot gives the site network. Errors are JSON objects with an
+ * "error" member.
+ */
+func NewJobHandler(q *JobQueue) http.Handler {
+	return &jobHandler{q}
+}
+
+/*
+ * Write a value as JSON with a status code.
+ */
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+/*
+ * Write an error as JSON.
+ */
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSONResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+/*
+ * Return the status code for an error from the queue.
+ */
+func jobErrorStatus(err error) int {
+	switch err {
+	case ErrNoJob:
+		return http.StatusNotFound
+	case ErrQueueFull:
+		return http.StatusServiceUnavailable
+	case ErrJobFinished, ErrNoResults:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+func (h *jobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "jobs" || len(parts) > 3 || (len(parts) == 3 && parts[2] != "results") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var method string
+	switch len(parts) {
+	case 1:
+		method = "GET, POST"
+		if r.Method == "POST" {
+			h.submit(w, r)
+			return
+		} else if r.Method == "GET" {
+			writeJSONResponse(w, http.StatusOK, h.queue.Jobs())
+			return
+		}
+	case 2:
+		method = "GET, DELETE"
+		if r.Method == "GET" {
+			job, err := h.queue.Get(parts[1])
+			if err != nil {
+				writeJSONError(w, jobErrorStatus(err), err)
+				return
+			}
+			writeJSONResponse(w, http.StatusOK, job)
+			return
+		} else if r.Method == "DELETE" {
+			job, err := h.queue.Cancel(parts[1])
+			if err != nil {
+				writeJSONError(w, jobErrorStatus(err), err)
+				return
+			}
+			writeJSONResponse(w, http.StatusOK, job)
+			return
+		}
+	case 3:
+		method = "GET"
+		if r.Method == "GET" {
+			h.results(w, r, parts[1])
+			return
+		}
+	}
+
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+/*
+ * Queue a job from the request body.
+ */
+func (h *jobHandler) submit(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := req.site(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.queue.Submit(req)
+	if err != nil {
+		writeJSONError(w, jobErrorStatus(err), err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSONResponse(w, http.StatusAccepted, job)
+}
+
+/*
+ * Write the results of a job in the format asked for.
+ */
+func (h *jobHandler) results(w http.ResponseWriter, r *http.Request, id string) {
+	root, err := h.queue.Results(id)
+	if err != nil {
+		writeJSONError(w, jobErrorStatus(err), err)
+		return
+	}
+
+	var buf bytes.Buffer
+	var contentType string
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		contentType = "application/json"
+		err = WriteJSON(&buf, root)
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		err = writeTable(&buf, root, r.URL.Query().Get("table"))
+	case "dot":
+		contentType = "text/vnd.graphviz"
+		err = WriteDOT(&buf, root)
+	default:
+		writeJSONError(w, http.StatusBadRequest, errBadFormat)
+		return
+	}
+	if err == errBadTable {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	buf.WriteTo(w)
+}
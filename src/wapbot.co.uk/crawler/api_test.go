@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/*
+ * Make a request to the API and return the response status and body.
+ */
+func callAPI(server *httptest.Server, method string, path string, body string) (int, string) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func Test_JobHandler(t *testing.T) {
+	Convey("Given the API of a job queue", t, func() {
+		fetcher := newBlockingFetcher()
+		q, err := NewJobQueue(t.TempDir(), 2, 1, fetcher)
+		So(err, ShouldBeNil)
+		defer q.Close()
+		server := httptest.NewServer(NewJobHandler(q))
+		defer server.Close()
+
+		status, body := callAPI(server, "POST", "/jobs", `{"seed": "http://one.link/"}`)
+		So(status, ShouldEqual, http.StatusAccepted)
+		var job Job
+		So(json.Unmarshal([]byte(body), &job), ShouldBeNil)
+		So(job.State, ShouldEqual, JobState_Queued)
+		So(job.Request.Seed, ShouldEqual, "http://one.link/")
+		<-fetcher.started
+
+		Convey("Check that the job is listed and its progress shown", func() {
+			status, body := callAPI(server, "GET", "/jobs", "")
+			So(status, ShouldEqual, http.StatusOK)
+			var jobs []Job
+			So(json.Unmarshal([]byte(body), &jobs), ShouldBeNil)
+			So(len(jobs), ShouldEqual, 1)
+			So(jobs[0].ID, ShouldEqual, job.ID)
+
+			status, body = callAPI(server, "GET", "/jobs/"+job.ID, "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(json.Unmarshal([]byte(body), &job), ShouldBeNil)
+			So(job.State, ShouldEqual, JobState_Running)
+
+			status, _ = callAPI(server, "GET", "/jobs/"+job.ID+"/results", "")
+			So(status, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("Check that the results of the finished job are served", func() {
+			close(fetcher.release)
+			So(waitForJob(q, job.ID).State, ShouldEqual, JobState_Done)
+
+			status, body := callAPI(server, "GET", "/jobs/"+job.ID+"/results", "")
+			So(status, ShouldEqual, http.StatusOK)
+			root, err := ReadJSON(strings.NewReader(body))
+			So(err, ShouldBeNil)
+			So(root.Title, ShouldEqual, "Held")
+
+			status, body = callAPI(server, "GET", "/jobs/"+job.ID+"/results?format=csv", "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldStartWith, "uri,title,status,depth,inbound,outbound,pagerank\nhttp://one.link/,Held,200,0,0,0,")
+
+			status, body = callAPI(server, "GET", "/jobs/"+job.ID+"/results?format=csv&table=links", "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "source,target,anchor,rel,title,location,scope\n")
+
+			status, body = callAPI(server, "GET", "/jobs/"+job.ID+"/results?format=dot", "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldStartWith, "digraph site {\n")
+
+			status, _ = callAPI(server, "GET", "/jobs/"+job.ID+"/results?format=xml", "")
+			So(status, ShouldEqual, http.StatusBadRequest)
+			status, _ = callAPI(server, "GET", "/jobs/"+job.ID+"/results?format=csv&table=other", "")
+			So(status, ShouldEqual, http.StatusBadRequest)
+
+			status, _ = callAPI(server, "DELETE", "/jobs/"+job.ID, "")
+			So(status, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("Check that the job can be cancelled", func() {
+			status, _ := callAPI(server, "DELETE", "/jobs/"+job.ID, "")
+			So(status, ShouldEqual, http.StatusOK)
+			So(waitForJob(q, job.ID).State, ShouldEqual, JobState_Cancelled)
+		})
+
+		Convey("Check that the queue being full is reported", func() {
+			for i := 0; i < 2; i++ {
+				status, _ := callAPI(server, "POST", "/jobs", `{"seed": "http://two.link/"}`)
+				So(status, ShouldEqual, http.StatusAccepted)
+			}
+			status, body := callAPI(server, "POST", "/jobs", `{"seed": "http://two.link/"}`)
+			So(status, ShouldEqual, http.StatusServiceUnavailable)
+			So(body, ShouldContainSubstring, `"error": "the job queue is full"`)
+		})
+
+		Convey("Check that bad requests are refused", func() {
+			status, _ := callAPI(server, "POST", "/jobs", `{"seed": `)
+			So(status, ShouldEqual, http.StatusBadRequest)
+			status, _ = callAPI(server, "POST", "/jobs", `{"seed": "http://one.link/", "colour": "red"}`)
+			So(status, ShouldEqual, http.StatusBadRequest)
+			status, body := callAPI(server, "POST", "/jobs", `{"seed": "one.link"}`)
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(body, ShouldContainSubstring, "seed must be an http or https URL")
+
+			status, _ = callAPI(server, "GET", "/jobs/missing", "")
+			So(status, ShouldEqual, http.StatusNotFound)
+			status, _ = callAPI(server, "GET", "/other", "")
+			So(status, ShouldEqual, http.StatusNotFound)
+			status, _ = callAPI(server, "PUT", "/jobs", "")
+			So(status, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
@@ -0,0 +1,179 @@
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/puerkitobio/goquery"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Credentials sent to a host with each request. If Token is set it is
+ * sent as a bearer token, otherwise the username and password are sent
+ * with basic authentication.
+ */
+type Credentials struct {
+	Username string
+	Password string
+	Token    string
+}
+
+/*
+ * Set the Authorization header of a request from the credentials, unless
+ * the request sets it itself.
+ */
+func (c *Credentials) authorize(req *http.Request) {
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+/**
+ * Matches the paths and queries of links which log out, such as /logout,
+ * /account/sign-out.aspx or /wp-login.php?action=logout, which an
+ * authenticated crawl should not follow. Only a whole path segment, which
+ * may have an extension, or a whole query value matches, so /blog-outreach
+ * does not.
+ */
+var LogoutPattern = regexp.MustCompile(`(?i)(/(log|sign)[-_]?(out|off)(\.\w+)?(/|\?|#|$)|[?&][^=&#]*=(log|sign)[-_]?(out|off)(&|#|$))`)
+
+/**
+ * LoginForm describes a form to log in with before crawling.
+ */
+type LoginForm struct {
+	// The page holding the login form. If the page has a form with a
+	// password field the form is submitted to its action, along with its
+	// hidden fields such as CSRF tokens. Otherwise the fields are posted
+	// to the URL itself.
+	URL string
+	// The fields to submit, such as the username and password.
+	Fields url.Values
+}
+
+/*
+ * Find the login form in a page and return the URL it submits to and the
+ * values of its fields. Returns nil if there is no login form.
+ */
+func findLoginForm(base *url.URL, body []byte) (*url.URL, url.Values) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil
+	}
+
+	form := doc.Find("form").FilterFunction(func(i int, s *goquery.Selection) bool {
+		return s.Find(`input[type="password"]`).Length() > 0
+	}).First()
+	if form.Length() == 0 {
+		return nil, nil
+	}
+
+	action := base
+	if href, exists := form.Attr("action"); exists && href != "" {
+		if u, err := base.Parse(href); err == nil {
+			action = u
+		}
+	}
+
+	values := make(url.Values)
+	form.Find("input[name]").Each(func(i int, s *goquery.Selection) {
+		name, _ := s.Attr("name")
+		value, _ := s.Attr("value")
+		switch strings.ToLower(s.AttrOr("type", "text")) {
+		case "submit", "button", "image", "reset", "file":
+			return
+		case "checkbox", "radio":
+			if _, checked := s.Attr("checked"); !checked {
+				return
+			}
+		}
+		values.Add(name, value)
+	})
+
+	return action, values
+}
+
+/**
+ * Log in by submitting a form, so that the session cookies the site sets
+ * are kept in the client's cookie jar for the crawl to send. An error is
+ * returned if the client has no cookie jar, if the login is refused with
+ * an error status or if submitting the form sets no new cookies, as when a
+ * site shows the form again for wrong credentials.
+ */
+func Login(client *http.Client, form *LoginForm) error {
+	if client.Jar == nil {
+		return errors.New("logging in needs a client with a cookie jar")
+	}
+
+	page, err := url.Parse(form.URL)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the page first, both for its form and for any cookies the
+	// site needs to see when the form is submitted.
+	action, values := page, make(url.Values)
+	resp, err := client.Get(page.String())
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 400 {
+		if formAction, formValues := findLoginForm(resp.Request.URL, body); formAction != nil {
+			action, values = formAction, formValues
+		}
+	}
+
+	for name, fieldValues := range form.Fields {
+		values[name] = fieldValues
+	}
+
+	// The cookies from fetching the page, such as a CSRF token, do not show
+	// that the login worked.
+	before := cookieSet(client.Jar, action, page)
+	resp, err = client.PostForm(action.String(), values)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login refused with HTTP %d", resp.StatusCode)
+	}
+	for cookie := range cookieSet(client.Jar, action, resp.Request.URL) {
+		if !before[cookie] {
+			return nil
+		}
+	}
+
+	return errors.New("login did not set or change any cookies")
+}
+
+/*
+ * Return the names and values of the cookies a jar holds for the URLs.
+ */
+func cookieSet(jar http.CookieJar, urls ...*url.URL) map[string]bool {
+	cookies := make(map[string]bool)
+	for _, u := range urls {
+		for _, cookie := range jar.Cookies(u) {
+			cookies[cookie.Name+"="+cookie.Value] = true
+		}
+	}
+
+	return cookies
+}
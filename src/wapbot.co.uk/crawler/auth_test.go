@@ -0,0 +1,190 @@
+package crawler
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_Credentials(t *testing.T) {
+	Convey("Given a server which echoes the Authorization header", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Header.Get("Authorization"))
+		}))
+		defer server.Close()
+		host := server.Listener.Addr().String()
+
+		Convey("Check that basic credentials are sent to their host", func() {
+			creds := map[string]*Credentials{host: {Username: "user", Password: "pass"}}
+			client, err := NewClient(&ClientOptions{Credentials: creds})
+			So(err, ShouldBeNil)
+			body, err := clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "Basic dXNlcjpwYXNz")
+
+			body, err = clientGet(client, server.URL, http.Header{"Authorization": []string{"Bearer mine"}})
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "Bearer mine")
+		})
+
+		Convey("Check that a bearer token can be given for the host name", func() {
+			creds := map[string]*Credentials{"127.0.0.1": {Token: "secret"}}
+			client, err := NewClient(&ClientOptions{Credentials: creds})
+			So(err, ShouldBeNil)
+			body, err := clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "Bearer secret")
+		})
+
+		Convey("Check that credentials are not sent to other hosts", func() {
+			var sent string
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sent = r.Header.Get("Authorization")
+			}))
+			defer proxy.Close()
+
+			proxyURL, _ := url.Parse(proxy.URL)
+			creds := map[string]*Credentials{host: {Token: "secret"}}
+			client, err := NewClient(&ClientOptions{Credentials: creds, Proxy: proxyURL})
+			So(err, ShouldBeNil)
+			_, err = clientGet(client, "http://other.link/", nil)
+			So(err, ShouldBeNil)
+			So(sent, ShouldEqual, "")
+		})
+	})
+}
+
+func Test_LogoutPattern(t *testing.T) {
+	Convey("Check that logout links match", t, func() {
+		for _, link := range []string{
+			"/logout", "/account/sign-out/", "/log_off?next=/", "/SignOut",
+			"/logout.php", "/account/logout.aspx", "/auth/logout#x",
+			"/wp-login.php?action=logout", "/session?do=sign-off&next=/",
+		} {
+			So(LogoutPattern.MatchString(link), ShouldBeTrue)
+		}
+	})
+
+	Convey("Check that only whole path segments and query values match", t, func() {
+		for _, link := range []string{
+			"/catalog-outlet/", "/blog-outreach", "/backlog_offline", "/dialog-outcomes",
+			"/logouts", "/logout-policy.html", "/search?q=logouts", "/wp-login.php?action=login",
+		} {
+			So(LogoutPattern.MatchString(link), ShouldBeFalse)
+		}
+	})
+}
+
+func Test_Login(t *testing.T) {
+	Convey("Given a site with a login form", t, func() {
+		var posted url.Values
+		mux := http.NewServeMux()
+		mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "token"})
+			io.WriteString(w, `<html><body>
+				<form method="get" action="/search"><input name="q"></form>
+				<form method="post" action="/session">
+					<input type="hidden" name="csrf" value="token">
+					<input name="username">
+					<input type="password" name="password">
+					<input type="checkbox" name="remember" value="yes" checked>
+					<input type="submit" name="go" value="Log in">
+				</form></body></html>`)
+		})
+		mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			posted = r.PostForm
+			cookie, err := r.Cookie("csrf")
+			if err != nil || cookie.Value != r.PostForm.Get("csrf") || r.PostForm.Get("password") != "pass" {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+		})
+		mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+			if _, err := r.Cookie("session"); err != nil {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+			io.WriteString(w, "Welcome back")
+		})
+		mux.HandleFunc("/signin", func(w http.ResponseWriter, r *http.Request) {
+			// Wrong credentials show the form again, with a 200 status.
+			if r.Method == "POST" && r.PostFormValue("password") == "pass" {
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "ghi", Path: "/"})
+				http.Redirect(w, r, "/account", http.StatusSeeOther)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "token"})
+			io.WriteString(w, `<html><body><p>Wrong username or password</p>
+				<form method="post"><input name="username"><input type="password" name="password"></form>
+				</body></html>`)
+		})
+		mux.HandleFunc("/api/login", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			posted = r.PostForm
+			if r.Method == "POST" {
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "def", Path: "/"})
+			}
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		jar, _ := cookiejar.New(nil)
+		client, err := NewClient(&ClientOptions{Jar: jar})
+		So(err, ShouldBeNil)
+
+		Convey("Check that the form is submitted with its hidden fields", func() {
+			fields := url.Values{"username": {"user"}, "password": {"pass"}}
+			err := Login(client, &LoginForm{URL: server.URL + "/login", Fields: fields})
+			So(err, ShouldBeNil)
+			So(posted, ShouldResemble, url.Values{
+				"csrf":     {"token"},
+				"username": {"user"},
+				"password": {"pass"},
+				"remember": {"yes"},
+			})
+
+			body, err := clientGet(client, server.URL+"/account", nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "Welcome back")
+		})
+
+		Convey("Check that a refused login fails", func() {
+			fields := url.Values{"username": {"user"}, "password": {"wrong"}}
+			err := Login(client, &LoginForm{URL: server.URL + "/login", Fields: fields})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "403")
+		})
+
+		Convey("Check that a login which shows the form again fails", func() {
+			fields := url.Values{"username": {"user"}, "password": {"wrong"}}
+			err := Login(client, &LoginForm{URL: server.URL + "/signin", Fields: fields})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "did not set or change any cookies")
+
+			fields.Set("password", "pass")
+			err = Login(client, &LoginForm{URL: server.URL + "/signin", Fields: fields})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Check that the fields are posted to a URL without a form", func() {
+			fields := url.Values{"token": {"xyz"}}
+			err := Login(client, &LoginForm{URL: server.URL + "/api/login", Fields: fields})
+			So(err, ShouldBeNil)
+			So(posted, ShouldResemble, fields)
+		})
+
+		Convey("Check that a client without a cookie jar cannot log in", func() {
+			client, err := NewClient(nil)
+			So(err, ShouldBeNil)
+			err = Login(client, &LoginForm{URL: server.URL + "/login"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
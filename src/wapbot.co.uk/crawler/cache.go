@@ -0,0 +1,179 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type CacheMode int
+
+const (
+	// Use the cached response if it is younger than MaxAge, otherwise
+	// fetch and cache a new one.
+	CacheMode_IfFresh CacheMode = iota
+	// Always fetch and cache a new response.
+	CacheMode_Refresh
+	// Only ever use cached responses. Nothing is fetched.
+	CacheMode_Only
+)
+
+// Returned in CacheMode_Only when a URL has no cached response.
+var ErrNotCached = errors.New("not in cache")
+
+/**
+ * A CachingFetcher keeps a copy of every response on disk, keyed by URL,
+ * so that a crawl can be replayed through ProcessPage without network
+ * access. Only GET requests are cached. Requests which fail outright, and
+ * responses which are usually temporary, such as server errors and 429s,
+ * are not cached at all.
+ */
+type CachingFetcher struct {
+	// The fetcher used on a cache miss.
+	Fetcher Fetcher
+	// The directory holding the cached responses.
+	Dir  string
+	Mode CacheMode
+	// How long a cached response is fresh for in CacheMode_IfFresh. Zero
+	// means cached responses never go stale.
+	MaxAge time.Duration
+}
+
+/**
+ * Create a caching fetcher storing its responses in dir.
+ */
+func NewCachingFetcher(dir string, fetcher Fetcher, mode CacheMode) (*CachingFetcher, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cf := new(CachingFetcher)
+	cf.Fetcher = fetcher
+	cf.Dir = dir
+	cf.Mode = mode
+
+	return cf, nil
+}
+
+/*
+ * Return the file a URL is cached in.
+ */
+func (cf *CachingFetcher) path(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	name := hex.EncodeToString(sum[:])
+
+	return filepath.Join(cf.Dir, name[:2], name)
+}
+
+func (cf *CachingFetcher) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != "" && req.Method != "GET" {
+		if cf.Mode == CacheMode_Only {
+			return nil, ErrNotCached
+		}
+		return cf.Fetcher.Do(req)
+	}
+
+	path := cf.path(req.URL.String())
+
+	if cf.Mode != CacheMode_Refresh {
+		resp, err := cf.load(path, req)
+		if err == nil {
+			return resp, nil
+		} else if cf.Mode == CacheMode_Only {
+			return nil, ErrNotCached
+		}
+	}
+
+	resp, err := cf.Fetcher.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !cacheable(resp) {
+		return resp, nil
+	}
+	if err := cf.save(path, resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+/*
+ * Return whether a response is worth caching. Server errors and 429s
+ * usually pass, and caching one would keep serving it after they had,
+ * including to the retries of a RetryPolicy.
+ */
+func cacheable(resp *http.Response) bool {
+	return classifyFailure(resp, nil) == FailureClass_None
+}
+
+/*
+ * Read a cached response, failing if there is none or, when the cache is
+ * not being used exclusively, if it has gone stale.
+ */
+func (cf *CachingFetcher) load(path string, req *http.Request) (*http.Response, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if cf.Mode == CacheMode_IfFresh && cf.MaxAge > 0 && time.Since(info.ModTime()) > cf.MaxAge {
+		return nil, errors.New("cached response is stale")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+}
+
+/*
+ * Write a response to the cache. The body is read in full and replaced
+ * so that the caller can still read it.
+ */
+func (cf *CachingFetcher) save(path string, resp *http.Response) error {
+	body, err := readBody(resp)
+	if err != nil {
+		return err
+	}
+
+	data, err := dumpResponse(resp, body)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can never leave a
+	// truncated response in the cache. Each save has its own, as workers
+	// may save the same URL at once.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,157 @@
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_CachingFetcher(t *testing.T) {
+	Convey("Given a caching fetcher in front of a site", t, func() {
+		dir := t.TempDir()
+		var mu sync.Mutex
+		fetches := 0
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			fetches++
+			mu.Unlock()
+			resp := new(http.Response)
+			if req.URL.Path == "/" {
+				resp.StatusCode = 200
+				resp.Header = http.Header{"Content-Type": []string{"text/html"}, "Etag": []string{`"v1"`}}
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Home</title></head><body><a href="/a">a</a><a href="/b">b</a><img src="i.png"/></body></html>`)}
+			} else if req.URL.Path == "/busy" {
+				resp.StatusCode = 503
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`Service Unavailable`)}
+			} else if req.URL.Path == "/a" {
+				resp.StatusCode = 200
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Page A</title></head><body><a href="/">home</a></body></html>`)}
+			} else {
+				resp.StatusCode = 404
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Not Found</title></head></html>`)}
+			}
+			return resp, nil
+		})
+		offline := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("offline")
+		})
+
+		cache, err := NewCachingFetcher(dir, site, CacheMode_Refresh)
+		So(err, ShouldBeNil)
+
+		req, _ := http.NewRequest("GET", "http://local.link/", nil)
+		resp, err := cache.Do(req)
+		So(err, ShouldBeNil)
+		body, _ := io.ReadAll(resp.Body)
+		So(string(body), ShouldContainSubstring, "<title>Home</title>")
+		So(fetches, ShouldEqual, 1)
+
+		Convey("Refresh mode always fetches", func() {
+			_, err := cache.Do(req)
+			So(err, ShouldBeNil)
+			So(fetches, ShouldEqual, 2)
+		})
+
+		Convey("Cache only mode serves the stored status, headers and body", func() {
+			cache.Fetcher = offline
+			cache.Mode = CacheMode_Only
+			resp, err := cache.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.Header.Get("Content-Type"), ShouldEqual, "text/html")
+			So(resp.Header.Get("ETag"), ShouldEqual, `"v1"`)
+			body, _ := io.ReadAll(resp.Body)
+			So(string(body), ShouldContainSubstring, "<title>Home</title>")
+
+			Convey("And fails for anything not cached", func() {
+				req, _ := http.NewRequest("GET", "http://local.link/a", nil)
+				_, err := cache.Do(req)
+				So(err, ShouldEqual, ErrNotCached)
+			})
+		})
+
+		Convey("If fresh mode uses the cache until it goes stale", func() {
+			cache.Mode = CacheMode_IfFresh
+			cache.MaxAge = time.Hour
+			_, err := cache.Do(req)
+			So(err, ShouldBeNil)
+			So(fetches, ShouldEqual, 1)
+
+			old := time.Now().Add(-2 * time.Hour)
+			So(os.Chtimes(cache.path(req.URL.String()), old, old), ShouldBeNil)
+			_, err = cache.Do(req)
+			So(err, ShouldBeNil)
+			So(fetches, ShouldEqual, 2)
+		})
+
+		Convey("Temporary failures are not cached", func() {
+			cache.Mode = CacheMode_IfFresh
+			req, _ := http.NewRequest("GET", "http://local.link/busy", nil)
+			for i := 0; i < 2; i++ {
+				resp, err := cache.Do(req)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, 503)
+			}
+			So(fetches, ShouldEqual, 3)
+			_, err := os.Stat(cache.path(req.URL.String()))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Saving the same URL at once leaves one whole response", func() {
+			var wg sync.WaitGroup
+			errs := make([]error, 8)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					resp, err := cache.Do(req)
+					if err == nil {
+						resp.Body.Close()
+					}
+					errs[i] = err
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				So(err, ShouldBeNil)
+			}
+
+			entries, err := os.ReadDir(filepath.Dir(cache.path(req.URL.String())))
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+
+			cache.Fetcher = offline
+			cache.Mode = CacheMode_Only
+			resp, err := cache.Do(req)
+			So(err, ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			So(string(body), ShouldContainSubstring, "<title>Home</title>")
+		})
+
+		Convey("A crawl replayed from the cache is identical to the original", func() {
+			u, _ := url.Parse("http://local.link/")
+			live, err := ProcessPageWithOptions(u, &Options{Fetcher: cache})
+			So(err, ShouldBeNil)
+
+			cache.Fetcher = offline
+			cache.Mode = CacheMode_Only
+			u, _ = url.Parse("http://local.link/")
+			replayed, err := ProcessPageWithOptions(u, &Options{Fetcher: cache})
+			So(err, ShouldBeNil)
+
+			var liveJSON, replayedJSON bytes.Buffer
+			So(WriteJSON(&liveJSON, live), ShouldBeNil)
+			So(WriteJSON(&replayedJSON, replayed), ShouldBeNil)
+			So(replayedJSON.String(), ShouldEqual, liveJSON.String())
+			So(len(replayed.Pages), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,111 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+type ChangeType int
+
+const (
+	// There was no previous crawl to compare the page with.
+	ChangeType_None ChangeType = iota
+	ChangeType_New
+	ChangeType_Changed
+	ChangeType_Unchanged
+)
+
+func getChangeString(ct ChangeType) string {
+	if ct == ChangeType_New {
+		return "New"
+	} else if ct == ChangeType_Changed {
+		return "Changed"
+	} else if ct == ChangeType_Unchanged {
+		return "Unchanged"
+	} else {
+		return "Unknown"
+	}
+}
+
+/**
+ * This struct lists the URIs of the pages in a crawl by how they have
+ * changed since a previous crawl.
+ */
+type ChangeReport struct {
+	New       []string
+	Changed   []string
+	Unchanged []string
+	// Pages in the previous crawl which are no longer linked to.
+	Gone []string
+}
+
+/**
+ * Compare the pages reachable from root with the records of a previous
+ * crawl. Pages which were not compared, whose change is ChangeType_None,
+ * are left out.
+ */
+func NewChangeReport(root *Page, previous []*PageRecord) *ChangeReport {
+	report := new(ChangeReport)
+
+	current := make(map[string]bool)
+	root.Walk(func(p *Page) {
+		current[p.URI] = true
+		switch p.Change {
+		case ChangeType_Changed:
+			report.Changed = append(report.Changed, p.URI)
+		case ChangeType_Unchanged:
+			report.Unchanged = append(report.Unchanged, p.URI)
+		case ChangeType_New:
+			report.New = append(report.New, p.URI)
+		}
+	})
+
+	for _, rec := range previous {
+		if !current[rec.URI] {
+			report.Gone = append(report.Gone, rec.URI)
+		}
+	}
+
+	sort.Strings(report.New)
+	sort.Strings(report.Changed)
+	sort.Strings(report.Unchanged)
+	sort.Strings(report.Gone)
+
+	return report
+}
+
+/**
+ * Dump the report.
+ */
+func (r *ChangeReport) Dump() {
+	var buf bytes.Buffer
+	r.DumpToBuffer(&buf)
+	fmt.Print(buf.String())
+}
+
+/**
+ * Dump the report to a buffer.
+ */
+func (r *ChangeReport) DumpToBuffer(buf *bytes.Buffer) {
+	sections := []struct {
+		ct   ChangeType
+		uris []string
+	}{
+		{ChangeType_New, r.New},
+		{ChangeType_Changed, r.Changed},
+		{ChangeType_Unchanged, r.Unchanged},
+	}
+
+	for _, s := range sections {
+		fmt.Fprintf(buf, "%s: %d\n", getChangeString(s.ct), len(s.uris))
+		for _, uri := range s.uris {
+			fmt.Fprintf(buf, "%sURI: %s\n", indent(1), uri)
+		}
+	}
+
+	fmt.Fprintf(buf, "Gone: %d\n", len(r.Gone))
+	for _, uri := range r.Gone {
+		fmt.Fprintf(buf, "%sURI: %s\n", indent(1), uri)
+	}
+}
@@ -0,0 +1,48 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_ChangeReport(t *testing.T) {
+	Convey("Given a crawl compared with a previous crawl", t, func() {
+		page := NewPage("aaaa", "Title")
+		page.Change = ChangeType_Changed
+		lpage := NewPage("bbbb", "Title2")
+		lpage.Change = ChangeType_Unchanged
+		npage := NewPage("cccc", "Title3")
+		npage.Change = ChangeType_New
+		// A page which was not compared is not new.
+		upage := NewPage("eeee", "Title4")
+		page.AddPage(lpage)
+		lpage.AddPage(npage)
+		npage.AddPage(page)
+		npage.AddPage(upage)
+
+		previous := []*PageRecord{{URI: "aaaa"}, {URI: "bbbb"}, {URI: "dddd"}}
+
+		Convey("Check that the pages are grouped correctly", func() {
+			report := NewChangeReport(page, previous)
+			So(report.New, ShouldResemble, []string{"cccc"})
+			So(report.Changed, ShouldResemble, []string{"aaaa"})
+			So(report.Unchanged, ShouldResemble, []string{"bbbb"})
+			So(report.Gone, ShouldResemble, []string{"dddd"})
+
+			Convey("And check that the report is dumped correctly", func() {
+				var buf bytes.Buffer
+				report.DumpToBuffer(&buf)
+				So(buf.String(), ShouldEqual, `New: 1
+ URI: cccc
+Changed: 1
+ URI: aaaa
+Unchanged: 1
+ URI: bbbb
+Gone: 1
+ URI: dddd
+`)
+			})
+		})
+	})
+}
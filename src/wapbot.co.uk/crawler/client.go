@@ -0,0 +1,174 @@
+package crawler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// The User-Agent crawls identify themselves with by default.
+const DefaultUserAgent = "Mozilla/5.0 (compatible; wapbot-crawler/1.0; +https://github.com/yoink00/crawler)"
+
+/**
+ * ClientOptions controls the HTTP client made by NewClient. Zero timeouts
+ * mean no limit.
+ */
+type ClientOptions struct {
+	// The longest time to wait to connect to a server, including the TLS
+	// handshake.
+	ConnectTimeout time.Duration
+	// The longest time to wait for the response headers once a request
+	// has been sent.
+	ReadTimeout time.Duration
+	// The longest time a request may take, including reading the body.
+	Timeout time.Duration
+	// Sent with every request. DefaultUserAgent is sent if it is empty.
+	UserAgent string
+	// Sent with every request, unless the request sets the header itself.
+	// A Host header replaces the host of every request.
+	Headers http.Header
+	// The proxy to make requests through, an http, https or socks5 URL.
+	// If nil the proxy is taken from the environment, as with
+	// http.DefaultTransport.
+	Proxy *url.URL
+	// A file of PEM encoded certificates to trust as well as the system's.
+	CAFile string
+	// Skip verifying the certificates of servers. For testing only.
+	InsecureSkipVerify bool
+	// Keeps the cookies sites set and sends them back. If nil cookies are
+	// not kept.
+	Jar http.CookieJar
+	// Credentials to send to each host, keyed by host name. A key with a
+	// port, such as staging.example.com:8443, matches only that port.
+	Credentials map[string]*Credentials
+}
+
+/**
+ * The client options used when a crawl is not given a fetcher, which stop
+ * slow servers from holding up a crawl forever.
+ */
+var DefaultClientOptions = ClientOptions{
+	ConnectTimeout: 10 * time.Second,
+	ReadTimeout:    30 * time.Second,
+	Timeout:        2 * time.Minute,
+}
+
+// The client used by crawls which are not given a fetcher.
+var defaultClient, _ = NewClient(nil)
+
+/*
+ * A round tripper which adds headers and credentials to each request.
+ */
+type headerTransport struct {
+	transport   http.RoundTripper
+	userAgent   string
+	headers     http.Header
+	credentials map[string]*Credentials
+}
+
+/*
+ * The context key of a *sentRequest, which the clients made by NewClient
+ * fill in with each request as it is sent, once its headers are added.
+ */
+type sentRequestKey struct{}
+
+type sentRequest struct {
+	req *http.Request
+}
+
+func (ht *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A round tripper must not change the request it is given.
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", ht.userAgent)
+	}
+	for name, values := range ht.headers {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Host" && len(values) > 0 {
+			// The Host header is taken from the request, not its headers.
+			req.Host = values[0]
+		} else if _, exists := req.Header[name]; !exists {
+			req.Header[name] = values
+		}
+	}
+
+	// Credentials are looked up for each request, so they are not sent on
+	// when a redirect leads to another host.
+	creds, exists := ht.credentials[req.URL.Host]
+	if !exists {
+		creds, exists = ht.credentials[req.URL.Hostname()]
+	}
+	if exists {
+		creds.authorize(req)
+	}
+
+	if sent, ok := req.Context().Value(sentRequestKey{}).(*sentRequest); ok {
+		sent.req = req
+	}
+
+	return ht.transport.RoundTrip(req)
+}
+
+/**
+ * Make an HTTP client, which can be used as a Fetcher, configured by the
+ * options. Nil options are the same as DefaultClientOptions.
+ */
+func NewClient(opts *ClientOptions) (*http.Client, error) {
+	if opts == nil {
+		opts = &DefaultClientOptions
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	transport.ResponseHeaderTimeout = opts.ReadTimeout
+
+	if opts.Proxy != nil {
+		switch opts.Proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", opts.Proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+
+	if opts.CAFile != "" || opts.InsecureSkipVerify {
+		config := new(tls.Config)
+		config.InsecureSkipVerify = opts.InsecureSkipVerify
+		if opts.CAFile != "" {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, err
+			}
+
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New(opts.CAFile + " holds no PEM encoded certificates")
+			}
+			config.RootCAs = pool
+		}
+		transport.TLSClientConfig = config
+	}
+
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	client := new(http.Client)
+	client.Transport = &headerTransport{transport, userAgent, opts.Headers, opts.Credentials}
+	client.Timeout = opts.Timeout
+	client.Jar = opts.Jar
+
+	return client, nil
+}
@@ -0,0 +1,147 @@
+package crawler
+
+import (
+	"encoding/pem"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+ * Make a GET request with the client and return the response body.
+ */
+func clientGet(client *http.Client, uri string, header http.Header) (string, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func Test_NewClient(t *testing.T) {
+	Convey("Given a server which echoes the request", t, func() {
+		echo := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			io.WriteString(w, r.Host+" "+r.Header.Get("User-Agent")+" "+r.Header.Get("X-Token"))
+		}
+		server := httptest.NewServer(http.HandlerFunc(echo))
+		defer server.Close()
+
+		Convey("Check that the default user agent is sent", func() {
+			client, err := NewClient(nil)
+			So(err, ShouldBeNil)
+			body, err := clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldContainSubstring, " "+DefaultUserAgent+" ")
+		})
+
+		Convey("Check that the user agent and headers can be set", func() {
+			headers := http.Header{}
+			headers.Set("X-Token", "secret")
+			headers.Set("Host", "staging.example.com")
+			client, err := NewClient(&ClientOptions{UserAgent: "tester/1.0", Headers: headers})
+			So(err, ShouldBeNil)
+
+			body, err := clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "staging.example.com tester/1.0 secret")
+
+			body, err = clientGet(client, server.URL, http.Header{"X-Token": []string{"mine"}})
+			So(err, ShouldBeNil)
+			So(body, ShouldEndWith, " mine")
+		})
+
+		Convey("Check that a slow server times out", func() {
+			client, err := NewClient(&ClientOptions{Timeout: 50 * time.Millisecond})
+			So(err, ShouldBeNil)
+			_, err = clientGet(client, server.URL+"/slow", nil)
+			So(err, ShouldNotBeNil)
+			So(classifyFailure(nil, err), ShouldEqual, FailureClass_Timeout)
+
+			client, err = NewClient(&ClientOptions{ReadTimeout: 50 * time.Millisecond})
+			So(err, ShouldBeNil)
+			_, err = clientGet(client, server.URL+"/slow", nil)
+			So(err, ShouldNotBeNil)
+
+			_, err = clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Check that requests are made through a proxy", func() {
+			var proxied string
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proxied = r.URL.String()
+				io.WriteString(w, "from the proxy")
+			}))
+			defer proxy.Close()
+
+			proxyURL, _ := url.Parse(proxy.URL)
+			client, err := NewClient(&ClientOptions{Proxy: proxyURL})
+			So(err, ShouldBeNil)
+			body, err := clientGet(client, "http://one.link/page", nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "from the proxy")
+			So(proxied, ShouldEqual, "http://one.link/page")
+
+			_, err = NewClient(&ClientOptions{Proxy: &url.URL{Scheme: "ftp", Host: "proxy"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a server with a certificate from an unknown authority", t, func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "secure")
+		}))
+		defer server.Close()
+
+		Convey("Check that it is refused by default", func() {
+			client, err := NewClient(nil)
+			So(err, ShouldBeNil)
+			_, err = clientGet(client, server.URL, nil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Check that it is trusted when its certificate is in the CA file", func() {
+			caFile := filepath.Join(t.TempDir(), "ca.pem")
+			cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+			So(os.WriteFile(caFile, cert, 0644), ShouldBeNil)
+
+			client, err := NewClient(&ClientOptions{CAFile: caFile})
+			So(err, ShouldBeNil)
+			body, err := clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "secure")
+
+			So(os.WriteFile(caFile, []byte("not a certificate"), 0644), ShouldBeNil)
+			_, err = NewClient(&ClientOptions{CAFile: caFile})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Check that verification can be skipped", func() {
+			client, err := NewClient(&ClientOptions{InsecureSkipVerify: true})
+			So(err, ShouldBeNil)
+			body, err := clientGet(client, server.URL, nil)
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "secure")
+		})
+	})
+}
@@ -0,0 +1,308 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/**
+ * Return the canonical form of a URI so that trivially different
+ * spellings of the same page compare equal. The scheme and host are
+ * lower-cased, default ports, fragments and trailing slashes are removed
+ * and an empty path becomes "/".
+ */
+func CanonicalURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) ||
+		(u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+		u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+	}
+	u.Fragment = ""
+	if u.Path == "" && u.Host != "" {
+		u.Path = "/"
+	} else if len(u.Path) > 1 {
+		u.Path = strings.TrimRight(u.Path, "/")
+	}
+
+	return u.String()
+}
+
+/*
+ * Resolve a possibly relative reference against the page it was found on.
+ */
+func resolveURI(base string, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	return b.ResolveReference(r).String()
+}
+
+type TitleChange struct {
+	URI string `json:"uri"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type LinkChange struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	// The error or HTTP status the target failed with.
+	Reason string `json:"reason"`
+}
+
+type AssetChange struct {
+	Page string    `json:"page"`
+	URI  string    `json:"uri"`
+	Type AssetType `json:"type"`
+}
+
+/**
+ * This struct describes what has changed on a site between two crawls.
+ * Pages are matched by their canonical URI.
+ */
+type Diff struct {
+	Added        []string       `json:"added"`
+	Removed      []string       `json:"removed"`
+	TitleChanges []*TitleChange `json:"title_changes"`
+	// Links to broken pages which were not broken in the old crawl.
+	BrokenLinks []*LinkChange `json:"broken_links"`
+	// Assets which were not used anywhere in the old crawl.
+	AssetsAdded []*AssetChange `json:"assets_added"`
+}
+
+/*
+ * Index the pages reachable from root by canonical URI.
+ */
+func canonicalPages(root *Page) map[string]*Page {
+	pages := make(map[string]*Page)
+	root.Walk(func(p *Page) {
+		pages[CanonicalURI(p.URI)] = p
+	})
+
+	return pages
+}
+
+/*
+ * Return the reason a page is broken.
+ */
+func brokenReason(p *Page) string {
+	if p.Error != "" {
+		return p.Error
+	}
+
+	return fmt.Sprintf("HTTP %d", p.Status)
+}
+
+/*
+ * Return the broken links from the pages, keyed by canonical source and
+ * target URI.
+ */
+func brokenLinks(pages map[string]*Page) map[[2]string]*LinkChange {
+	broken := make(map[[2]string]*LinkChange)
+	for uri, p := range pages {
+		for _, np := range p.Pages {
+			if np.IsBroken() {
+				target := CanonicalURI(np.URI)
+				broken[[2]string{uri, target}] = &LinkChange{uri, target, brokenReason(np)}
+			}
+		}
+	}
+
+	return broken
+}
+
+/*
+ * Sort links by source and then target.
+ */
+func sortLinkChanges(links []*LinkChange) {
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Source != links[j].Source {
+			return links[i].Source < links[j].Source
+		}
+		return links[i].Target < links[j].Target
+	})
+}
+
+/**
+ * Return the links to broken pages from the pages reachable from root,
+ * sorted by source and then target. URIs are canonical.
+ */
+func BrokenLinks(root *Page) []*LinkChange {
+	var links []*LinkChange
+	for _, link := range brokenLinks(canonicalPages(root)) {
+		links = append(links, link)
+	}
+	sortLinkChanges(links)
+
+	return links
+}
+
+/*
+ * Return the canonical, absolute URIs of every asset used by the pages.
+ */
+func assetURIs(pages map[string]*Page) map[string]bool {
+	assets := make(map[string]bool)
+	for _, p := range pages {
+		for _, a := range p.Assets {
+			assets[CanonicalURI(resolveURI(p.URI, a.URI))] = true
+		}
+	}
+
+	return assets
+}
+
+/**
+ * Compare the crawl rooted at oldRoot with the crawl rooted at newRoot.
+ */
+func NewDiff(oldRoot *Page, newRoot *Page) *Diff {
+	// Use empty rather than nil slices so the JSON has no nulls.
+	diff := new(Diff)
+	diff.Added = []string{}
+	diff.Removed = []string{}
+	diff.TitleChanges = []*TitleChange{}
+	diff.BrokenLinks = []*LinkChange{}
+	diff.AssetsAdded = []*AssetChange{}
+
+	oldPages := canonicalPages(oldRoot)
+	newPages := canonicalPages(newRoot)
+
+	for uri, np := range newPages {
+		op, exists := oldPages[uri]
+		if !exists {
+			diff.Added = append(diff.Added, uri)
+		} else if op.Title != np.Title {
+			diff.TitleChanges = append(diff.TitleChanges, &TitleChange{uri, op.Title, np.Title})
+		}
+	}
+	for uri := range oldPages {
+		if _, exists := newPages[uri]; !exists {
+			diff.Removed = append(diff.Removed, uri)
+		}
+	}
+
+	oldBroken := brokenLinks(oldPages)
+	for key, link := range brokenLinks(newPages) {
+		if _, exists := oldBroken[key]; !exists {
+			diff.BrokenLinks = append(diff.BrokenLinks, link)
+		}
+	}
+
+	oldAssets := assetURIs(oldPages)
+	for uri, p := range newPages {
+		for _, a := range p.Assets {
+			asset := CanonicalURI(resolveURI(p.URI, a.URI))
+			if !oldAssets[asset] {
+				diff.AssetsAdded = append(diff.AssetsAdded, &AssetChange{uri, asset, a.Type})
+			}
+		}
+	}
+
+	diff.sort()
+
+	return diff
+}
+
+func (d *Diff) sort() {
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Slice(d.TitleChanges, func(i, j int) bool {
+		return d.TitleChanges[i].URI < d.TitleChanges[j].URI
+	})
+	sortLinkChanges(d.BrokenLinks)
+	sort.Slice(d.AssetsAdded, func(i, j int) bool {
+		if d.AssetsAdded[i].Page != d.AssetsAdded[j].Page {
+			return d.AssetsAdded[i].Page < d.AssetsAdded[j].Page
+		}
+		return d.AssetsAdded[i].URI < d.AssetsAdded[j].URI
+	})
+}
+
+/**
+ * Report whether anything changed.
+ */
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.TitleChanges) == 0 &&
+		len(d.BrokenLinks) == 0 && len(d.AssetsAdded) == 0
+}
+
+/**
+ * Dump the diff.
+ */
+func (d *Diff) Dump() {
+	var buf bytes.Buffer
+	d.DumpToBuffer(&buf)
+	fmt.Print(buf.String())
+}
+
+/**
+ * Dump the diff to a buffer in a human readable form.
+ */
+func (d *Diff) DumpToBuffer(buf *bytes.Buffer) {
+	if d.IsEmpty() {
+		fmt.Fprintf(buf, "No changes\n")
+		return
+	}
+
+	if len(d.Added) > 0 {
+		fmt.Fprintf(buf, "Pages Added:\n")
+		for _, uri := range d.Added {
+			fmt.Fprintf(buf, "%s+ %s\n", indent(1), uri)
+		}
+	}
+
+	if len(d.Removed) > 0 {
+		fmt.Fprintf(buf, "Pages Removed:\n")
+		for _, uri := range d.Removed {
+			fmt.Fprintf(buf, "%s- %s\n", indent(1), uri)
+		}
+	}
+
+	if len(d.TitleChanges) > 0 {
+		fmt.Fprintf(buf, "Titles Changed:\n")
+		for _, tc := range d.TitleChanges {
+			fmt.Fprintf(buf, "%sURI: %s\n", indent(1), tc.URI)
+			fmt.Fprintf(buf, "%s- %s\n", indent(2), tc.Old)
+			fmt.Fprintf(buf, "%s+ %s\n", indent(2), tc.New)
+		}
+	}
+
+	if len(d.BrokenLinks) > 0 {
+		fmt.Fprintf(buf, "New Broken Links:\n")
+		for _, l := range d.BrokenLinks {
+			fmt.Fprintf(buf, "%s%s -> %s (%s)\n", indent(1), l.Source, l.Target, l.Reason)
+		}
+	}
+
+	if len(d.AssetsAdded) > 0 {
+		fmt.Fprintf(buf, "Assets Added:\n")
+		for _, a := range d.AssetsAdded {
+			fmt.Fprintf(buf, "%s+ %s (%s) on %s\n", indent(1), a.URI, getTypeString(a.Type), a.Page)
+		}
+	}
+}
+
+/**
+ * Write the diff as JSON.
+ */
+func (d *Diff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_CanonicalURI(t *testing.T) {
+	Convey("Check that URIs are canonicalised", t, func() {
+		So(CanonicalURI("HTTP://Local.Link"), ShouldEqual, "http://local.link/")
+		So(CanonicalURI("http://local.link:80/a/"), ShouldEqual, "http://local.link/a")
+		So(CanonicalURI("https://local.link:443/a#top"), ShouldEqual, "https://local.link/a")
+		So(CanonicalURI("http://local.link:8080/a?b=c"), ShouldEqual, "http://local.link:8080/a?b=c")
+	})
+}
+
+func Test_Diff(t *testing.T) {
+	Convey("Given two crawls of the same site", t, func() {
+		oldRoot := NewPage("http://local.link/", "Home")
+		oldA := NewPage("http://local.link/a", "Page A")
+		oldB := NewPage("http://local.link/b", "Page B")
+		oldRoot.AddPage(oldA)
+		oldRoot.AddPage(oldB)
+		asset, _ := NewAsset("style.css", AssetType_CSS)
+		oldRoot.AddAsset(asset)
+
+		newRoot := NewPage("http://LOCAL.link", "Home")
+		newA := NewPage("http://local.link/a/", "Page A has a new title")
+		newC := NewPage("http://local.link/c", "Page C")
+		missing := NewPage("http://local.link/d", "")
+		missing.Status = 404
+		newRoot.AddPage(newA)
+		newRoot.AddPage(newC)
+		newRoot.AddPage(missing)
+		asset, _ = NewAsset("/style.css", AssetType_CSS)
+		newRoot.AddAsset(asset)
+		asset, _ = NewAsset("logo.png", AssetType_IMG)
+		newA.AddAsset(asset)
+
+		Convey("Check that the differences are found", func() {
+			diff := NewDiff(oldRoot, newRoot)
+			So(diff.Added, ShouldResemble, []string{"http://local.link/c", "http://local.link/d"})
+			So(diff.Removed, ShouldResemble, []string{"http://local.link/b"})
+			So(len(diff.TitleChanges), ShouldEqual, 1)
+			So(*diff.TitleChanges[0], ShouldResemble, TitleChange{"http://local.link/a", "Page A", "Page A has a new title"})
+			So(len(diff.BrokenLinks), ShouldEqual, 1)
+			So(*diff.BrokenLinks[0], ShouldResemble, LinkChange{"http://local.link/", "http://local.link/d", "HTTP 404"})
+			So(len(diff.AssetsAdded), ShouldEqual, 1)
+			So(*diff.AssetsAdded[0], ShouldResemble, AssetChange{"http://local.link/a", "http://local.link/a/logo.png", AssetType_IMG})
+
+			Convey("And check that it is dumped correctly", func() {
+				var buf bytes.Buffer
+				diff.DumpToBuffer(&buf)
+				So(buf.String(), ShouldEqual, `Pages Added:
+ + http://local.link/c
+ + http://local.link/d
+Pages Removed:
+ - http://local.link/b
+Titles Changed:
+ URI: http://local.link/a
+  - Page A
+  + Page A has a new title
+New Broken Links:
+ http://local.link/ -> http://local.link/d (HTTP 404)
+Assets Added:
+ + http://local.link/a/logo.png (Image) on http://local.link/a
+`)
+			})
+
+			Convey("And check that it is written as JSON", func() {
+				var buf bytes.Buffer
+				So(diff.WriteJSON(&buf), ShouldBeNil)
+				decoded := new(Diff)
+				So(json.Unmarshal(buf.Bytes(), decoded), ShouldBeNil)
+				So(decoded, ShouldResemble, diff)
+			})
+		})
+
+		Convey("Check that a crawl has no differences from itself", func() {
+			diff := NewDiff(oldRoot, oldRoot)
+			So(diff.IsEmpty(), ShouldBeTrue)
+
+			var buf bytes.Buffer
+			diff.DumpToBuffer(&buf)
+			So(buf.String(), ShouldEqual, "No changes\n")
+		})
+	})
+}
+
+func Test_BrokenLinks(t *testing.T) {
+	Convey("Given a crawl with broken pages", t, func() {
+		root := NewPage("http://local.link/", "Home")
+		a := NewPage("http://local.link/a", "Page A")
+		missing := NewPage("http://local.link/missing", "")
+		missing.Status = 404
+		failed := NewPage("http://local.link/failed", "")
+		failed.Error = "connection refused"
+		root.AddPage(a)
+		root.AddPage(missing)
+		a.AddPage(failed)
+		a.AddPage(missing)
+
+		Convey("Check that every link to them is found", func() {
+			links := BrokenLinks(root)
+			So(len(links), ShouldEqual, 3)
+			So(*links[0], ShouldResemble, LinkChange{"http://local.link/", "http://local.link/missing", "HTTP 404"})
+			So(*links[1], ShouldResemble, LinkChange{"http://local.link/a", "http://local.link/failed", "connection refused"})
+			So(*links[2], ShouldResemble, LinkChange{"http://local.link/a", "http://local.link/missing", "HTTP 404"})
+		})
+
+		Convey("Check that a crawl without them has no broken links", func() {
+			So(BrokenLinks(a.Pages[0]), ShouldBeEmpty)
+		})
+	})
+}
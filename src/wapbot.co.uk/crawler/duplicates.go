@@ -0,0 +1,302 @@
+package crawler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/puerkitobio/goquery"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The similarity above which pages are usually near duplicates.
+const DefaultSimilarity = 0.9
+
+/*
+ * Return the main visible text of a document with whitespace collapsed.
+ * The text is taken from the main or article element if there is one, and
+ * from the body otherwise, ignoring navigation, headers, footers and
+ * anything that is not displayed.
+ */
+func mainText(doc *goquery.Document) string {
+	sel := doc.Find("main, [role=main]").First()
+	if sel.Length() == 0 {
+		sel = doc.Find("article").First()
+	}
+	if sel.Length() == 0 {
+		sel = doc.Find("body")
+	}
+
+	sel = sel.Clone()
+	sel.Find("script, style, noscript, template, nav, header, footer, aside, [hidden]").Remove()
+
+	// Collect the words of each text node separately so that the text of
+	// adjacent elements is not run together.
+	var words []string
+	var walk func(*goquery.Selection)
+	walk = func(sel *goquery.Selection) {
+		sel.Contents().Each(func(_ int, c *goquery.Selection) {
+			if goquery.NodeName(c) == "#text" {
+				words = append(words, strings.Fields(c.Text())...)
+			} else {
+				walk(c)
+			}
+		})
+	}
+	walk(sel)
+
+	return strings.Join(words, " ")
+}
+
+/*
+ * Return the SimHash of some text, built from its overlapping runs of
+ * three words. Similar texts have hashes which differ in few bits.
+ */
+func simHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var features []string
+	if len(words) < 3 {
+		features = words
+	}
+	for i := 0; i+3 <= len(words); i++ {
+		features = append(features, strings.Join(words[i:i+3], " "))
+	}
+
+	var v [64]int
+	for _, f := range features {
+		h := fnv.New64a()
+		h.Write([]byte(f))
+		sum := h.Sum64()
+		for i := range v {
+			if sum&(1<<uint(i)) != 0 {
+				v[i]++
+			} else {
+				v[i]--
+			}
+		}
+	}
+
+	var hash uint64
+	for i := range v {
+		if v[i] > 0 {
+			hash |= 1 << uint(i)
+		}
+	}
+
+	return hash
+}
+
+/*
+ * Set the text digest and SimHash of a page from its document.
+ */
+func fingerprint(page *Page, doc *goquery.Document) {
+	text := mainText(doc)
+	if text == "" {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(text))
+	page.TextDigest = hex.EncodeToString(sum[:])
+	page.SimHash = simHash(text)
+}
+
+/**
+ * Return how similar two SimHashes are, from 0 to 1.
+ */
+func Similarity(a uint64, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+/**
+ * This struct lists clusters of pages with the same or similar main text.
+ * Each cluster is a sorted list of URIs, and the clusters are sorted by
+ * their first URI.
+ */
+type DuplicateReport struct {
+	// Pages whose main text is identical.
+	Exact [][]string `json:"exact"`
+	// Pages whose main text is at least Threshold similar, where the
+	// cluster holds more than one distinct text. Pages are clustered
+	// transitively, so two pages in a cluster may be less similar than
+	// the threshold.
+	Near      [][]string `json:"near"`
+	Threshold float64    `json:"threshold"`
+}
+
+/*
+ * Sort the URIs of each cluster, and the clusters, dropping clusters of
+ * one page.
+ */
+func sortClusters(groups map[string][]string) [][]string {
+	var clusters [][]string
+	for _, uris := range groups {
+		if len(uris) > 1 {
+			sort.Strings(uris)
+			clusters = append(clusters, uris)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i][0] < clusters[j][0]
+	})
+
+	return clusters
+}
+
+/*
+ * Join SimHashes which are at least threshold similar, directly or through
+ * other hashes, and return the index of the group each hash is in.
+ *
+ * Rather than comparing every pair, the 64 bits are split into one more
+ * block than the number of bits two similar hashes may differ by. Two
+ * similar hashes must then be equal in at least one block, so only hashes
+ * which share a block are compared.
+ */
+func groupSimilar(hashes []uint64, threshold float64) []int {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	join := func(i int, j int) {
+		if find(i) != find(j) && Similarity(hashes[i], hashes[j]) >= threshold {
+			parent[find(i)] = find(j)
+		}
+	}
+
+	maxDistance := int(math.Floor((1-threshold)*64 + 1e-9))
+	if maxDistance >= 64 {
+		// Every pair is similar enough.
+		for i := 1; i < len(hashes); i++ {
+			join(i, 0)
+		}
+	} else if maxDistance >= 0 {
+		blocks := maxDistance + 1
+		for b := 0; b < blocks; b++ {
+			start, end := uint(b*64/blocks), uint((b+1)*64/blocks)
+			mask := (^uint64(0) >> (64 - (end - start))) << start
+
+			buckets := make(map[uint64][]int)
+			for i, h := range hashes {
+				buckets[h&mask] = append(buckets[h&mask], i)
+			}
+			for _, bucket := range buckets {
+				for x := range bucket {
+					for y := x + 1; y < len(bucket); y++ {
+						join(bucket[x], bucket[y])
+					}
+				}
+			}
+		}
+	}
+
+	groups := make([]int, len(hashes))
+	for i := range hashes {
+		groups[i] = find(i)
+	}
+
+	return groups
+}
+
+/**
+ * Find the duplicate pages reachable from root. Pages which are broken or
+ * have no text are ignored.
+ */
+func NewDuplicateReport(root *Page, threshold float64) *DuplicateReport {
+	report := new(DuplicateReport)
+	report.Threshold = threshold
+
+	var pages []*Page
+	root.Walk(func(p *Page) {
+		if p.TextDigest != "" && !p.IsBroken() {
+			pages = append(pages, p)
+		}
+	})
+
+	exact := make(map[string][]string)
+	for _, p := range pages {
+		exact[p.TextDigest] = append(exact[p.TextDigest], p.URI)
+	}
+	report.Exact = sortClusters(exact)
+
+	hashes := make([]uint64, len(pages))
+	for i, p := range pages {
+		hashes[i] = p.SimHash
+	}
+	groups := groupSimilar(hashes, threshold)
+
+	near := make(map[string][]string)
+	digests := make(map[string]map[string]bool)
+	for i, p := range pages {
+		key := pages[groups[i]].URI
+		near[key] = append(near[key], p.URI)
+		if digests[key] == nil {
+			digests[key] = make(map[string]bool)
+		}
+		digests[key][p.TextDigest] = true
+	}
+	for key := range near {
+		if len(digests[key]) < 2 {
+			delete(near, key)
+		}
+	}
+	report.Near = sortClusters(near)
+
+	return report
+}
+
+/**
+ * Dump the report.
+ */
+func (r *DuplicateReport) Dump() {
+	var buf bytes.Buffer
+	r.DumpToBuffer(&buf)
+	fmt.Print(buf.String())
+}
+
+/**
+ * Dump the report to a buffer.
+ */
+func (r *DuplicateReport) DumpToBuffer(buf *bytes.Buffer) {
+	sections := []struct {
+		name     string
+		clusters [][]string
+	}{
+		{"Exact duplicates", r.Exact},
+		{fmt.Sprintf("Near duplicates (%.0f%% similar)", r.Threshold*100), r.Near},
+	}
+
+	for _, s := range sections {
+		fmt.Fprintf(buf, "%s: %d\n", s.name, len(s.clusters))
+		for i, cluster := range s.clusters {
+			fmt.Fprintf(buf, "%sCluster %d:\n", indent(1), i+1)
+			for _, uri := range cluster {
+				fmt.Fprintf(buf, "%sURI: %s\n", indent(2), uri)
+			}
+		}
+	}
+}
+
+/**
+ * Write the report as JSON.
+ */
+func (r *DuplicateReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,190 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/puerkitobio/goquery"
+	. "github.com/smartystreets/goconvey/convey"
+	"math/rand"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const article = `The crawler fetches every page of a site, follows the local links it
+finds and records the assets each page uses. Pages are fetched concurrently and
+each one is parsed once, so large sites can be crawled quickly. The results can
+be saved, compared with an earlier crawl, mirrored to disk or exported to other
+tools for analysis.
+
+Each page records its title, the status it was fetched with and the digest of
+its body, along with the remote pages it links to. A crawl can be checkpointed
+as it runs and resumed later without fetching the completed pages again, and a
+site can be crawled again incrementally, with conditional requests, so that
+only the pages which have changed are parsed.`
+
+func Test_MainText(t *testing.T) {
+	Convey("Given a page with navigation around an article", t, func() {
+		doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+			<nav>Home About</nav>
+			<main><h1>Title</h1><script>var x;</script><p>Some
+				text</p><footer>Footer</footer></main>
+			<footer>Copyright</footer></body></html>`))
+
+		Convey("Check that only the main text is extracted", func() {
+			So(mainText(doc), ShouldEqual, "Title Some text")
+		})
+	})
+
+	Convey("Given a page without a main element", t, func() {
+		doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><head><title>T</title></head><body>
+			<header>Site</header><p>Body text</p><style>p {}</style></body></html>`))
+
+		Convey("Check that the body text is extracted", func() {
+			So(mainText(doc), ShouldEqual, "Body text")
+		})
+	})
+}
+
+func Test_SimHash(t *testing.T) {
+	Convey("Check that similar texts have similar hashes", t, func() {
+		edited := strings.Replace(article, "quickly", "fast", 1)
+		other := "A completely different page about something else, with its own words and none of the same phrases at all."
+
+		So(Similarity(simHash(article), simHash(article)), ShouldEqual, 1)
+		So(Similarity(simHash(article), simHash(edited)), ShouldBeGreaterThanOrEqualTo, DefaultSimilarity)
+		So(Similarity(simHash(article), simHash(other)), ShouldBeLessThan, DefaultSimilarity)
+		So(simHash("THE Crawler, fetches"), ShouldEqual, simHash("the crawler fetches"))
+	})
+}
+
+func Test_GroupSimilar(t *testing.T) {
+	Convey("Given hashes in clusters of small variations", t, func() {
+		rng := rand.New(rand.NewSource(1))
+		var hashes []uint64
+		for len(hashes) < 400 {
+			base := rng.Uint64()
+			for i := 0; i < 4; i++ {
+				h := base
+				for flips := rng.Intn(12); flips > 0; flips-- {
+					h ^= 1 << uint(rng.Intn(64))
+				}
+				hashes = append(hashes, h)
+			}
+		}
+
+		// Compare every pair to find the groups the buckets should give.
+		allPairs := func(threshold float64) []int {
+			groups := make([]int, len(hashes))
+			for i := range groups {
+				groups[i] = i
+			}
+			for changed := true; changed; {
+				changed = false
+				for i := range hashes {
+					for j := range hashes {
+						if groups[j] < groups[i] && Similarity(hashes[i], hashes[j]) >= threshold {
+							groups[i] = groups[j]
+							changed = true
+						}
+					}
+				}
+			}
+			return groups
+		}
+		// Two groupings are the same if they put the same hashes together.
+		samePartition := func(a []int, b []int) bool {
+			for i := range a {
+				for j := range a {
+					if (a[i] == a[j]) != (b[i] == b[j]) {
+						return false
+					}
+				}
+			}
+			return true
+		}
+
+		Convey("Check that the same groups are found as by comparing every pair", func() {
+			for _, threshold := range []float64{1, DefaultSimilarity, 0.85, 0.8, 0.5, 0} {
+				So(samePartition(groupSimilar(hashes, threshold), allPairs(threshold)), ShouldBeTrue)
+			}
+		})
+	})
+}
+
+func Test_DuplicateReport(t *testing.T) {
+	Convey("Given a crawl with duplicated pages", t, func() {
+		page := func(uri string, text string) *Page {
+			p := NewPage(uri, "")
+			doc, _ := goquery.NewDocumentFromReader(strings.NewReader("<html><body><nav>" + uri + "</nav><p>" + text + "</p></body></html>"))
+			fingerprint(p, doc)
+			return p
+		}
+
+		root := page("http://local.link/", "Welcome to the site, there is lots here to read.")
+		a := page("http://local.link/a", article)
+		print := page("http://local.link/a?print=1", article)
+		b := page("http://local.link/b", strings.Replace(article, "quickly", "fast", 1))
+		empty := page("http://local.link/empty", "")
+		blank := page("http://local.link/blank", "")
+		broken := page("http://local.link/broken", article)
+		broken.Status = 500
+		for _, p := range []*Page{a, print, b, empty, blank, broken} {
+			root.AddPage(p)
+		}
+
+		Convey("Check that the pages are clustered", func() {
+			report := NewDuplicateReport(root, DefaultSimilarity)
+			So(report.Exact, ShouldResemble, [][]string{{"http://local.link/a", "http://local.link/a?print=1"}})
+			So(report.Near, ShouldResemble, [][]string{{"http://local.link/a", "http://local.link/a?print=1", "http://local.link/b"}})
+
+			Convey("And check that the report is dumped correctly", func() {
+				var buf bytes.Buffer
+				report.DumpToBuffer(&buf)
+				So(buf.String(), ShouldEqual, `Exact duplicates: 1
+ Cluster 1:
+  URI: http://local.link/a
+  URI: http://local.link/a?print=1
+Near duplicates (90% similar): 1
+ Cluster 1:
+  URI: http://local.link/a
+  URI: http://local.link/a?print=1
+  URI: http://local.link/b
+`)
+			})
+
+			Convey("And check that the report is written as JSON", func() {
+				var buf bytes.Buffer
+				So(report.WriteJSON(&buf), ShouldBeNil)
+				decoded := new(DuplicateReport)
+				So(json.Unmarshal(buf.Bytes(), decoded), ShouldBeNil)
+				So(decoded, ShouldResemble, report)
+			})
+		})
+
+		Convey("Check that a threshold of one only finds identical texts", func() {
+			report := NewDuplicateReport(root, 1)
+			So(len(report.Exact), ShouldEqual, 1)
+			So(len(report.Near), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a page processed by the crawler", t, func() {
+		html := "<html><head><title>Title</title></head><body><p>" + article + "</p></body></html>"
+		u, _ := url.Parse("http://local.link/zzzz")
+		page, err := doProcessPage(u, u, &openCloseBuffer{bytes.NewBufferString(html)}, nil, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Check that the page was fingerprinted and the fingerprint is saved", func() {
+			So(page.TextDigest, ShouldNotBeEmpty)
+			So(page.SimHash, ShouldEqual, simHash(article))
+
+			data, err := json.Marshal(NewPageRecord(page, nil))
+			So(err, ShouldBeNil)
+			rec := new(PageRecord)
+			So(json.Unmarshal(data, rec), ShouldBeNil)
+			So(rec.Page().SimHash, ShouldEqual, page.SimHash)
+			So(rec.Page().TextDigest, ShouldEqual, page.TextDigest)
+		})
+	})
+}
@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+/**
+ * A Fetcher performs the HTTP requests made by a crawl. *http.Client
+ * satisfies this interface.
+ */
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+/*
+ * Adapt a plain get function into a Fetcher. Any request headers are
+ * ignored.
+ */
+type getterFetcher httpGetFunction
+
+func (g getterFetcher) Do(req *http.Request) (*http.Response, error) {
+	return g(req.URL.String())
+}
+
+/*
+ * Read a response body in full and replace it so that the caller can
+ * still read it.
+ */
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+/*
+ * Return a response with the given body in HTTP/1.1 wire format, suitable
+ * for reading back with http.ReadResponse.
+ */
+func dumpResponse(resp *http.Response, body []byte) ([]byte, error) {
+	dump := *resp
+	dump.Body = io.NopCloser(bytes.NewReader(body))
+	dump.ContentLength = int64(len(body))
+	dump.TransferEncoding = nil
+	dump.Close = false
+	if dump.ProtoMajor == 0 {
+		dump.ProtoMajor, dump.ProtoMinor = 1, 1
+	}
+
+	var buf bytes.Buffer
+	if err := dump.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,175 @@
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/**
+ * A FileFetcher serves a directory of static files as though it were a
+ * site at a virtual base URL, so that the output of a static site
+ * generator can be crawled before it is deployed. A request for a
+ * directory is served its index file, and a request for a path with no
+ * extension falls back to the same path with ".html" added. Missing files
+ * are served as 404 responses so that links to them show as broken.
+ */
+type FileFetcher struct {
+	// The directory holding the site.
+	Root string
+	// The URL the directory is served at.
+	Base *url.URL
+	// The file served for a directory. Defaults to "index.html".
+	Index string
+}
+
+/**
+ * Create a file fetcher serving the directory root at base.
+ */
+func NewFileFetcher(root string, base *url.URL) *FileFetcher {
+	ff := new(FileFetcher)
+	ff.Root = root
+	ff.Base = base
+	ff.Index = "index.html"
+
+	return ff
+}
+
+/*
+ * Map a request to the file it refers to, which may not exist.
+ */
+func (ff *FileFetcher) file(u *url.URL) (string, error) {
+	basePath := ff.Base.Path
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+
+	if u.Scheme != ff.Base.Scheme || u.Host != ff.Base.Host ||
+		(!strings.HasPrefix(u.Path, basePath) && u.Path+"/" != basePath) {
+		return "", errors.New(u.String() + " is outside of " + ff.Base.String())
+	}
+
+	rel := rootedPath(strings.TrimPrefix(u.Path, basePath))
+
+	return filepath.Join(ff.Root, filepath.FromSlash(rel)), nil
+}
+
+/*
+ * Clean a slash separated path as though it were absolute, so that its
+ * dot segments cannot climb above where it starts. Only the path itself
+ * is cleaned: once it is joined to a directory, a symbolic link in that
+ * directory may still lead outside it.
+ */
+func rootedPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+/*
+ * Find the file to serve, applying the index and ".html" rules.
+ */
+func (ff *FileFetcher) find(file string) (string, os.FileInfo) {
+	info, err := os.Stat(file)
+	if err == nil && info.IsDir() {
+		file = filepath.Join(file, ff.Index)
+		info, err = os.Stat(file)
+	} else if err != nil && filepath.Ext(file) == "" {
+		file += ".html"
+		info, err = os.Stat(file)
+	}
+	if err != nil || info.IsDir() {
+		return "", nil
+	}
+
+	return file, info
+}
+
+func (ff *FileFetcher) Do(req *http.Request) (*http.Response, error) {
+	name, err := ff.file(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := new(http.Response)
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	resp.Request = req
+	resp.Header = make(http.Header)
+
+	file, info := ff.find(name)
+	if info == nil {
+		resp.StatusCode = http.StatusNotFound
+		resp.Status = "404 Not Found"
+		resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+		resp.Body = io.NopCloser(bytes.NewBufferString("<html><head><title>Not Found</title></head></html>"))
+		return resp, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	lastModified := info.ModTime().UTC().Format(http.TimeFormat)
+	if req.Header.Get("If-Modified-Since") == lastModified {
+		resp.StatusCode = http.StatusNotModified
+		resp.Status = "304 Not Modified"
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+		return resp, nil
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	resp.Header.Set("Last-Modified", lastModified)
+	resp.ContentLength = int64(len(data))
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	return resp, nil
+}
+
+/**
+ * Create a file fetcher for a site given as a file:// URL or a plain
+ * path, and return it along with the URL to start crawling from. The path
+ * may be the site's directory or a page within it, in which case its
+ * directory is served.
+ */
+func NewFileSite(site string, base *url.URL) (*FileFetcher, *url.URL, error) {
+	if strings.HasPrefix(site, "file://") {
+		u, err := url.Parse(site)
+		if err != nil {
+			return nil, nil, err
+		}
+		site = filepath.FromSlash(u.Path)
+	}
+
+	info, err := os.Stat(site)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	root, page := site, ""
+	if !info.IsDir() {
+		root, page = filepath.Dir(site), filepath.Base(site)
+	}
+
+	seed := *base
+	if !strings.HasSuffix(seed.Path, "/") {
+		seed.Path += "/"
+	}
+	seed.Path += page
+
+	return NewFileFetcher(root, base), &seed, nil
+}
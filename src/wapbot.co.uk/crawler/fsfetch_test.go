@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FileFetcher(t *testing.T) {
+	Convey("Given a directory holding a static site", t, func() {
+		dir := t.TempDir()
+		write := func(name string, content string) {
+			file := filepath.Join(dir, filepath.FromSlash(name))
+			So(os.MkdirAll(filepath.Dir(file), 0755), ShouldBeNil)
+			So(os.WriteFile(file, []byte(content), 0644), ShouldBeNil)
+		}
+		write("index.html", `<html><head><title>Home</title><link rel="stylesheet" href="/css/site.css"/></head><body><a href="docs/">Docs</a> <a href="about">About</a> <a href="missing.html">Missing</a> <a href="/css/site.css">CSS</a></body></html>`)
+		write("docs/index.html", `<html><head><title>Docs</title></head><body><a href="../">Home</a></body></html>`)
+		write("about.html", `<html><head><title>About</title></head><body></body></html>`)
+		write("css/site.css", `body { color: black }`)
+
+		base, _ := url.Parse("http://localhost/site/")
+		ff := NewFileFetcher(dir, base)
+
+		Convey("Check that files are served with a content type", func() {
+			req, _ := http.NewRequest("GET", "http://localhost/site/css/site.css", nil)
+			resp, err := ff.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 200)
+			So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/css")
+			So(resp.Header.Get("Last-Modified"), ShouldNotEqual, "")
+			body, _ := io.ReadAll(resp.Body)
+			So(string(body), ShouldEqual, `body { color: black }`)
+
+			Convey("And that an unmodified file is not served again", func() {
+				req.Header.Set("If-Modified-Since", resp.Header.Get("Last-Modified"))
+				resp, err := ff.Do(req)
+				So(err, ShouldBeNil)
+				So(resp.StatusCode, ShouldEqual, 304)
+			})
+		})
+
+		Convey("Check that files outside the site are not served", func() {
+			req, _ := http.NewRequest("GET", "http://localhost/other/", nil)
+			_, err := ff.Do(req)
+			So(err, ShouldNotBeNil)
+
+			// Dot segments cannot climb out of the directory.
+			req, _ = http.NewRequest("GET", "http://localhost/site/../../etc/passwd", nil)
+			resp, err := ff.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 404)
+		})
+
+		Convey("Crawl the directory and check the pages and broken links", func() {
+			_, seed, err := NewFileSite("file://"+filepath.ToSlash(filepath.Join(dir, "index.html")), base)
+			So(err, ShouldBeNil)
+			So(seed.String(), ShouldEqual, "http://localhost/site/index.html")
+
+			_, seed, err = NewFileSite(dir, base)
+			So(err, ShouldBeNil)
+			So(seed.String(), ShouldEqual, "http://localhost/site/")
+
+			page, err := ProcessPageWithOptions(seed, &Options{Fetcher: ff})
+			So(err, ShouldBeNil)
+			So(page.Title, ShouldEqual, "Home")
+			So(len(page.Assets), ShouldEqual, 1)
+
+			titles := make(map[string]string)
+			broken := make(map[string]bool)
+			page.Walk(func(p *Page) {
+				titles[p.URI] = p.Title
+				broken[p.URI] = p.IsBroken()
+			})
+			So(titles["http://localhost/site/docs/"], ShouldEqual, "Docs")
+			So(titles["http://localhost/site/about"], ShouldEqual, "About")
+			So(broken["http://localhost/site/missing.html"], ShouldBeTrue)
+			So(broken["http://localhost/site/docs/"], ShouldBeFalse)
+			_, crawledCSS := titles["http://localhost/site/css/site.css"]
+			So(crawledCSS, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,393 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * A node of the site network: a local page, a remote page or an asset.
+ */
+type graphNode struct {
+	ID    string
+	URI   string
+	Title string
+	// One of "page", "remote" or the lower case asset type.
+	Type string
+	// The click depth and status are only known for local pages; they are
+	// -1 and 0 otherwise.
+	Depth  int
+	Status int
+}
+
+/*
+ * An edge of the site network. The type is "page", "remote" or "asset"
+ * for links to local pages, links to remote pages and asset use. Links
+ * have the first link on the source page to the target, if it is known.
+ */
+type graphEdge struct {
+	ID     string
+	Source string
+	Target string
+	Type   string
+	Link   *Link
+}
+
+/*
+ * Return the first link on a page to each target, keyed by canonical URI.
+ */
+func firstLinks(p *Page) map[string]*Link {
+	links := make(map[string]*Link)
+	for _, l := range p.Links {
+		key := CanonicalURI(l.URI)
+		if _, exists := links[key]; !exists {
+			links[key] = l
+		}
+	}
+
+	return links
+}
+
+/*
+ * Build the network of the pages reachable from root. Pages are ordered
+ * by URI, followed by the remote pages and assets in the order they are
+ * first found. Remote pages and assets are identified by canonical URI.
+ */
+func newGraph(root *Page) ([]*graphNode, []*graphEdge) {
+	var pages []*Page
+	root.Walk(func(p *Page) {
+		pages = append(pages, p)
+	})
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URI < pages[j].URI
+	})
+	depths := root.Depths()
+
+	var nodes []*graphNode
+	var edges []*graphEdge
+	addNode := func(n *graphNode) *graphNode {
+		n.ID = "n" + strconv.Itoa(len(nodes))
+		nodes = append(nodes, n)
+		return n
+	}
+	addEdge := func(source *graphNode, target *graphNode, typ string, link *Link) {
+		id := "e" + strconv.Itoa(len(edges))
+		edges = append(edges, &graphEdge{id, source.ID, target.ID, typ, link})
+	}
+
+	byPage := make(map[*Page]*graphNode)
+	for _, p := range pages {
+		byPage[p] = addNode(&graphNode{URI: p.URI, Title: p.Title, Type: "page", Depth: depths[p], Status: p.Status})
+	}
+
+	others := make(map[string]*graphNode)
+	other := func(uri string, typ string) *graphNode {
+		key := typ + " " + CanonicalURI(uri)
+		if n, exists := others[key]; exists {
+			return n
+		}
+		n := addNode(&graphNode{URI: uri, Type: typ, Depth: -1})
+		others[key] = n
+		return n
+	}
+
+	for _, p := range pages {
+		source := byPage[p]
+		links := firstLinks(p)
+		for _, np := range p.Pages {
+			addEdge(source, byPage[np], "page", links[CanonicalURI(np.URI)])
+		}
+		for _, rp := range p.RemotePages {
+			uri := resolveURI(p.URI, rp.URI)
+			addEdge(source, other(uri, "remote"), "remote", links[CanonicalURI(uri)])
+		}
+		for _, a := range p.Assets {
+			addEdge(source, other(resolveURI(p.URI, a.URI), strings.ToLower(getTypeString(a.Type))), "asset", nil)
+		}
+	}
+
+	return nodes, edges
+}
+
+/*
+ * Return the non-empty attributes of a link as key and value pairs. The
+ * key of the title attribute is given as node and edge attributes share
+ * their keys in GraphML.
+ */
+func linkAttributes(l *Link, titleKey string) [][2]string {
+	var attrs [][2]string
+	if l == nil {
+		return attrs
+	}
+
+	for _, attr := range [][2]string{
+		{"anchor", l.Text},
+		{"rel", l.Rel},
+		{titleKey, l.Title},
+		{"location", l.Location},
+	} {
+		if attr[1] != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	return attrs
+}
+
+/*
+ * The GraphML document structure.
+ */
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+/**
+ * Write the network of the pages reachable from root, their remote
+ * pages and their assets as GraphML. Nodes have uri, title, type, depth
+ * and status attributes and edges have a type of "page", "remote" or
+ * "asset". Links also have the anchor, rel, title and location of the
+ * first link between the pages.
+ */
+func WriteGraphML(w io.Writer, root *Page) error {
+	nodes, edges := newGraph(root)
+
+	doc := graphML{
+		Keys: []graphMLKey{
+			{"uri", "node", "uri", "string"},
+			{"title", "node", "title", "string"},
+			{"type", "node", "type", "string"},
+			{"depth", "node", "depth", "int"},
+			{"status", "node", "status", "int"},
+			{"edgetype", "edge", "type", "string"},
+			{"anchor", "edge", "anchor", "string"},
+			{"rel", "edge", "rel", "string"},
+			{"linktitle", "edge", "title", "string"},
+			{"location", "edge", "location", "string"},
+		},
+		Graph: graphMLGraph{ID: "site", EdgeDefault: "directed"},
+	}
+
+	for _, n := range nodes {
+		data := []graphMLData{{"uri", n.URI}, {"title", n.Title}, {"type", n.Type}}
+		if n.Depth >= 0 {
+			data = append(data, graphMLData{"depth", strconv.Itoa(n.Depth)})
+		}
+		if n.Status != 0 {
+			data = append(data, graphMLData{"status", strconv.Itoa(n.Status)})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{n.ID, data})
+	}
+	for _, e := range edges {
+		data := []graphMLData{{"edgetype", e.Type}}
+		for _, attr := range linkAttributes(e.Link, "linktitle") {
+			data = append(data, graphMLData{attr[0], attr[1]})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{e.ID, e.Source, e.Target, data})
+	}
+
+	return writeXML(w, doc)
+}
+
+/*
+ * The GEXF document structure.
+ */
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfNode struct {
+	ID     string      `xml:"id,attr"`
+	Label  string      `xml:"label,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID     string      `xml:"id,attr"`
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Values []gexfValue `xml:"attvalues>attvalue"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexf struct {
+	XMLName xml.Name  `xml:"http://gexf.net/1.3 gexf"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+/**
+ * Write the same network as WriteGraphML in GEXF 1.3 format. Nodes are
+ * labelled with their URI.
+ */
+func WriteGEXF(w io.Writer, root *Page) error {
+	nodes, edges := newGraph(root)
+
+	doc := gexf{
+		Version: "1.3",
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes: []gexfAttributes{
+				{"node", []gexfAttribute{
+					{"title", "title", "string"},
+					{"type", "type", "string"},
+					{"depth", "depth", "integer"},
+					{"status", "status", "integer"},
+				}},
+				{"edge", []gexfAttribute{
+					{"type", "type", "string"},
+					{"anchor", "anchor", "string"},
+					{"rel", "rel", "string"},
+					{"title", "title", "string"},
+					{"location", "location", "string"},
+				}},
+			},
+		},
+	}
+
+	for _, n := range nodes {
+		values := []gexfValue{{"title", n.Title}, {"type", n.Type}}
+		if n.Depth >= 0 {
+			values = append(values, gexfValue{"depth", strconv.Itoa(n.Depth)})
+		}
+		if n.Status != 0 {
+			values = append(values, gexfValue{"status", strconv.Itoa(n.Status)})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{n.ID, n.URI, values})
+	}
+	for _, e := range edges {
+		values := []gexfValue{{"type", e.Type}}
+		for _, attr := range linkAttributes(e.Link, "title") {
+			values = append(values, gexfValue{attr[0], attr[1]})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{e.ID, e.Source, e.Target, values})
+	}
+
+	return writeXML(w, doc)
+}
+
+// Escapes strings for DOT's double quoted IDs.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+ * Return DOT attributes as a bracketed list.
+ */
+func dotAttributes(attrs [][2]string) string {
+	var list []string
+	for _, attr := range attrs {
+		list = append(list, attr[0]+`="`+dotEscaper.Replace(attr[1])+`"`)
+	}
+
+	return "[" + strings.Join(list, ", ") + "]"
+}
+
+/**
+ * Write the same network as WriteGraphML in Graphviz DOT format. Nodes
+ * are labelled with their URI, remote pages are drawn dashed and assets
+ * as notes.
+ */
+func WriteDOT(w io.Writer, root *Page) error {
+	nodes, edges := newGraph(root)
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph site {\n")
+	for _, n := range nodes {
+		attrs := [][2]string{{"label", n.URI}, {"type", n.Type}}
+		if n.Title != "" {
+			attrs = append(attrs, [2]string{"title", n.Title})
+		}
+		if n.Depth >= 0 {
+			attrs = append(attrs, [2]string{"depth", strconv.Itoa(n.Depth)})
+		}
+		if n.Status != 0 {
+			attrs = append(attrs, [2]string{"status", strconv.Itoa(n.Status)})
+		}
+		if n.Type == "remote" {
+			attrs = append(attrs, [2]string{"style", "dashed"})
+		} else if n.Type != "page" {
+			attrs = append(attrs, [2]string{"shape", "note"})
+		}
+		fmt.Fprintf(&buf, "  %s %s;\n", n.ID, dotAttributes(attrs))
+	}
+	for _, e := range edges {
+		attrs := append([][2]string{{"type", e.Type}}, linkAttributes(e.Link, "title")...)
+		fmt.Fprintf(&buf, "  %s -> %s %s;\n", e.Source, e.Target, dotAttributes(attrs))
+	}
+	buf.WriteString("}\n")
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+/*
+ * Write an XML document with a declaration.
+ */
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,112 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/xml"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Graph(t *testing.T) {
+	Convey("Given a crawl with remote links and shared assets", t, func() {
+		root := NewPage("http://local.link/", "Home & away")
+		root.Status = 200
+		a := NewPage("http://local.link/a", "Page A")
+		a.Status = 404
+		root.AddPage(a)
+		a.AddPage(root)
+		asset, _ := NewAsset("/style.css", AssetType_CSS)
+		root.AddAsset(asset)
+		asset, _ = NewAsset("style.css", AssetType_CSS)
+		a.AddAsset(asset)
+		remote, _ := NewAsset("http://remote.link", AssetType_HTML)
+		root.AddRemotePage(remote)
+		remote, _ = NewAsset("http://remote.link/", AssetType_HTML)
+		a.AddRemotePage(remote)
+		toA := &Link{URI: "http://local.link/a/", Text: "Go to A", Title: "Page A", Location: "nav"}
+		root.AddLink(toA)
+		root.AddLink(&Link{URI: "http://local.link/a", Text: "A again"})
+		toRemote := &Link{URI: "http://remote.link/", Text: "Away", Rel: "nofollow", Remote: true}
+		root.AddLink(toRemote)
+
+		Convey("Check that remote pages and assets are shared nodes", func() {
+			nodes, edges := newGraph(root)
+			So(len(nodes), ShouldEqual, 4)
+			So(*nodes[0], ShouldResemble, graphNode{"n0", "http://local.link/", "Home & away", "page", 0, 200})
+			So(*nodes[1], ShouldResemble, graphNode{"n1", "http://local.link/a", "Page A", "page", 1, 404})
+			So(*nodes[2], ShouldResemble, graphNode{"n2", "http://remote.link", "", "remote", -1, 0})
+			So(*nodes[3], ShouldResemble, graphNode{"n3", "http://local.link/style.css", "", "css", -1, 0})
+
+			So(len(edges), ShouldEqual, 6)
+			So(*edges[0], ShouldResemble, graphEdge{"e0", "n0", "n1", "page", toA})
+			So(*edges[1], ShouldResemble, graphEdge{"e1", "n0", "n2", "remote", toRemote})
+			So(*edges[2], ShouldResemble, graphEdge{"e2", "n0", "n3", "asset", nil})
+			So(*edges[3], ShouldResemble, graphEdge{"e3", "n1", "n0", "page", nil})
+			So(*edges[4], ShouldResemble, graphEdge{"e4", "n1", "n2", "remote", nil})
+			So(*edges[5], ShouldResemble, graphEdge{"e5", "n1", "n3", "asset", nil})
+		})
+
+		Convey("Check that GraphML is written", func() {
+			var buf bytes.Buffer
+			So(WriteGraphML(&buf, root), ShouldBeNil)
+			So(buf.String(), ShouldStartWith, xml.Header)
+			So(buf.String(), ShouldContainSubstring, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+
+			doc := new(graphML)
+			So(xml.Unmarshal(buf.Bytes(), doc), ShouldBeNil)
+			So(doc.Graph.EdgeDefault, ShouldEqual, "directed")
+			So(len(doc.Graph.Nodes), ShouldEqual, 4)
+			So(doc.Graph.Nodes[0].Data, ShouldResemble, []graphMLData{
+				{"uri", "http://local.link/"}, {"title", "Home & away"}, {"type", "page"}, {"depth", "0"}, {"status", "200"},
+			})
+			So(doc.Graph.Nodes[3].Data, ShouldResemble, []graphMLData{
+				{"uri", "http://local.link/style.css"}, {"title", ""}, {"type", "css"},
+			})
+			So(len(doc.Graph.Edges), ShouldEqual, 6)
+			So(doc.Graph.Edges[0].Data, ShouldResemble, []graphMLData{
+				{"edgetype", "page"}, {"anchor", "Go to A"}, {"linktitle", "Page A"}, {"location", "nav"},
+			})
+			So(doc.Graph.Edges[1].Data, ShouldResemble, []graphMLData{{"edgetype", "remote"}, {"anchor", "Away"}, {"rel", "nofollow"}})
+			So(doc.Graph.Edges[3].Data, ShouldResemble, []graphMLData{{"edgetype", "page"}})
+		})
+
+		Convey("Check that GEXF is written", func() {
+			var buf bytes.Buffer
+			So(WriteGEXF(&buf, root), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, `<gexf xmlns="http://gexf.net/1.3" version="1.3">`)
+
+			doc := new(gexf)
+			So(xml.Unmarshal(buf.Bytes(), doc), ShouldBeNil)
+			So(len(doc.Graph.Attributes), ShouldEqual, 2)
+			So(len(doc.Graph.Nodes), ShouldEqual, 4)
+			So(doc.Graph.Nodes[1].Label, ShouldEqual, "http://local.link/a")
+			So(doc.Graph.Nodes[1].Values, ShouldResemble, []gexfValue{
+				{"title", "Page A"}, {"type", "page"}, {"depth", "1"}, {"status", "404"},
+			})
+			So(len(doc.Graph.Edges), ShouldEqual, 6)
+			So(doc.Graph.Edges[0].Values, ShouldResemble, []gexfValue{
+				{"type", "page"}, {"anchor", "Go to A"}, {"title", "Page A"}, {"location", "nav"},
+			})
+			So(doc.Graph.Edges[2].Values, ShouldResemble, []gexfValue{{"type", "asset"}})
+		})
+
+		Convey("Check that DOT is written", func() {
+			a.Title = `Page "A"`
+			var buf bytes.Buffer
+			So(WriteDOT(&buf, root), ShouldBeNil)
+			So(buf.String(), ShouldEqual, `digraph site {
+  n0 [label="http://local.link/", type="page", title="Home & away", depth="0", status="200"];
+  n1 [label="http://local.link/a", type="page", title="Page \"A\"", depth="1", status="404"];
+  n2 [label="http://remote.link", type="remote", style="dashed"];
+  n3 [label="http://local.link/style.css", type="css", shape="note"];
+  n0 -> n1 [type="page", anchor="Go to A", title="Page A", location="nav"];
+  n0 -> n2 [type="remote", anchor="Away", rel="nofollow"];
+  n0 -> n3 [type="asset"];
+  n1 -> n0 [type="page"];
+  n1 -> n2 [type="remote"];
+  n1 -> n3 [type="asset"];
+}
+`)
+		})
+	})
+}
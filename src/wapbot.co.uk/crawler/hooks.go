@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"strings"
+	"time"
+)
+
+/**
+ * Hooks are called as a crawl progresses so that it can be followed while
+ * it runs. Any of them may be nil. They may be called from several
+ * goroutines at once, so should be safe for concurrent use and return
+ * quickly.
+ */
+type Hooks struct {
+	// Called with each URI queued for fetching. From is the page which
+	// links to it, or nil for seeds and pages resumed from a store.
+	OnLinkDiscovered func(from *Page, uri string)
+	// Called as the request for a queued URI is made, once the crawl's
+	// delays and worker limits allow.
+	OnFetchStart func(uri string)
+	// Called once the response to a request has been read, or the request
+	// has failed. Every call to OnFetchStart is followed by one to
+	// OnFetched.
+	OnFetched func(*FetchEvent)
+	// Called with each page parsed from a response and how long parsing
+	// took, before the pages it links to are queued.
+	OnPageParsed func(page *Page, duration time.Duration)
+	// Called when a URI could not be fetched or parsed.
+	OnError func(uri string, err error)
+	// Called when a URI is left out of the crawl, with the reason. Links
+	// matching the avoid pattern are skipped without being queued. Queued
+	// URIs which are not fetched, because the crawl was stopped or no
+	// request could be made for them, are skipped with a reason for which
+	// Unfetched is true, so every call to OnLinkDiscovered is followed by
+	// one to OnFetchStart or one of those.
+	OnSkipped func(uri string, reason string)
+}
+
+// The reason a queued URI is skipped when the crawl is stopped before it
+// is fetched.
+const SkipReason_Stopped = "crawl stopped"
+
+// The start of the reason a queued URI is skipped when no request can be
+// made for it. The error follows.
+const SkipReason_InvalidRequest = "invalid request"
+
+/**
+ * Return whether a skip reason is one given to a queued URI which is not
+ * fetched, so that OnFetchStart is never called for it.
+ */
+func Unfetched(reason string) bool {
+	return reason == SkipReason_Stopped || strings.HasPrefix(reason, SkipReason_InvalidRequest+": ")
+}
+
+/**
+ * FetchEvent describes a response received during a crawl.
+ */
+type FetchEvent struct {
+	URI string
+	// The status of the response, or zero if there was none.
+	Status int
+	// The size of the body read. The bodies of responses which are not
+	// HTML are not read, so are counted as zero.
+	Bytes int64
+	// The time from making the request to reading the body, including any
+	// retries and the waits between them.
+	Duration time.Duration
+	// The number of times the request was retried.
+	Retries int
+	// Set if the request failed or the body could not be read.
+	Err error
+}
+
+/*
+ * The methods below call the hook if it is set. They may be called on a
+ * nil Hooks.
+ */
+
+func (h *Hooks) linkDiscovered(from *Page, uri string) {
+	if h != nil && h.OnLinkDiscovered != nil {
+		h.OnLinkDiscovered(from, uri)
+	}
+}
+
+func (h *Hooks) fetchStart(uri string) {
+	if h != nil && h.OnFetchStart != nil {
+		h.OnFetchStart(uri)
+	}
+}
+
+func (h *Hooks) fetched(e *FetchEvent) {
+	if h != nil && h.OnFetched != nil {
+		h.OnFetched(e)
+	}
+}
+
+func (h *Hooks) pageParsed(page *Page, duration time.Duration) {
+	if h != nil && h.OnPageParsed != nil {
+		h.OnPageParsed(page, duration)
+	}
+}
+
+func (h *Hooks) error(uri string, err error) {
+	if h != nil && h.OnError != nil {
+		h.OnError(uri, err)
+	}
+}
+
+func (h *Hooks) skipped(uri string, reason string) {
+	if h != nil && h.OnSkipped != nil {
+		h.OnSkipped(uri, reason)
+	}
+}
+
+/**
+ * Return hooks which call each of the given hooks in turn, so that
+ * several can follow one crawl. Nil hooks are ignored.
+ */
+func MultiHooks(hooks ...*Hooks) *Hooks {
+	return &Hooks{
+		OnLinkDiscovered: func(from *Page, uri string) {
+			for _, h := range hooks {
+				h.linkDiscovered(from, uri)
+			}
+		},
+		OnFetchStart: func(uri string) {
+			for _, h := range hooks {
+				h.fetchStart(uri)
+			}
+		},
+		OnFetched: func(e *FetchEvent) {
+			for _, h := range hooks {
+				h.fetched(e)
+			}
+		},
+		OnPageParsed: func(page *Page, duration time.Duration) {
+			for _, h := range hooks {
+				h.pageParsed(page, duration)
+			}
+		},
+		OnError: func(uri string, err error) {
+			for _, h := range hooks {
+				h.error(uri, err)
+			}
+		},
+		OnSkipped: func(uri string, reason string) {
+			for _, h := range hooks {
+				h.skipped(uri, reason)
+			}
+		},
+	}
+}
@@ -0,0 +1,163 @@
+package crawler
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * Hooks which record every event they are called with.
+ */
+type hookRecorder struct {
+	sync.Mutex
+	discovered []string
+	started    []string
+	fetched    map[string]*FetchEvent
+	parsed     []string
+	errors     []string
+	skipped    []string
+	// Events which came out of order.
+	bad []string
+}
+
+func (hr *hookRecorder) hooks() *Hooks {
+	hr.fetched = make(map[string]*FetchEvent)
+	seen := func(list []string, uri string) bool {
+		for _, s := range list {
+			if s == uri {
+				return true
+			}
+		}
+		return false
+	}
+
+	return &Hooks{
+		OnLinkDiscovered: func(from *Page, uri string) {
+			hr.Lock()
+			defer hr.Unlock()
+			if from != nil && !seen(hr.parsed, from.URI) {
+				hr.bad = append(hr.bad, "discovered before parsed: "+uri)
+			}
+			hr.discovered = append(hr.discovered, uri)
+		},
+		OnFetchStart: func(uri string) {
+			hr.Lock()
+			defer hr.Unlock()
+			if !seen(hr.discovered, uri) {
+				hr.bad = append(hr.bad, "started before discovered: "+uri)
+			}
+			hr.started = append(hr.started, uri)
+		},
+		OnFetched: func(e *FetchEvent) {
+			hr.Lock()
+			defer hr.Unlock()
+			if !seen(hr.started, e.URI) {
+				hr.bad = append(hr.bad, "fetched before started: "+e.URI)
+			}
+			hr.fetched[e.URI] = e
+		},
+		OnPageParsed: func(page *Page, duration time.Duration) {
+			hr.Lock()
+			defer hr.Unlock()
+			if hr.fetched[page.URI] == nil {
+				hr.bad = append(hr.bad, "parsed before fetched: "+page.URI)
+			}
+			hr.parsed = append(hr.parsed, page.URI)
+		},
+		OnError: func(uri string, err error) {
+			hr.Lock()
+			defer hr.Unlock()
+			hr.errors = append(hr.errors, uri+": "+err.Error())
+		},
+		OnSkipped: func(uri string, reason string) {
+			hr.Lock()
+			defer hr.Unlock()
+			hr.skipped = append(hr.skipped, uri+": "+reason)
+		},
+	}
+}
+
+func Test_Hooks(t *testing.T) {
+	Convey("Given a site with a broken link, an image and two forms of one URI", t, func() {
+		fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			body := "<html><head><title>Page</title></head></html>"
+			switch req.URL.Path {
+			case "/":
+				body = `<html><head><title>Home</title></head><body>
+					<a href="/a">A</a><a href="/a/">A again</a><a href="/broken">Broken</a>
+					<a href="/img.png">Image</a></body></html>`
+			case "/broken":
+				return nil, errors.New("connection refused")
+			case "/img.png":
+				resp.Header.Set("Content-Type", "image/png")
+			}
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		var hr hookRecorder
+		u, _ := url.Parse("http://local.link/")
+		_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Hooks: hr.hooks()})
+		So(err, ShouldBeNil)
+
+		Convey("Check that the events are in order", func() {
+			So(hr.bad, ShouldBeEmpty)
+		})
+
+		Convey("Check that each URI is discovered and fetched once", func() {
+			sort.Strings(hr.discovered)
+			So(hr.discovered, ShouldResemble, []string{
+				"http://local.link/", "http://local.link/a", "http://local.link/a/",
+				"http://local.link/broken", "http://local.link/img.png"})
+			So(len(hr.started), ShouldEqual, 5)
+		})
+
+		Convey("Check that the responses are described", func() {
+			So(len(hr.fetched), ShouldEqual, 5)
+			home := hr.fetched["http://local.link/"]
+			So(home.Status, ShouldEqual, 200)
+			So(home.Bytes, ShouldBeGreaterThan, 100)
+			So(home.Duration, ShouldBeGreaterThan, 0)
+			So(home.Err, ShouldBeNil)
+			So(hr.fetched["http://local.link/img.png"].Bytes, ShouldEqual, 0)
+			So(hr.fetched["http://local.link/img.png"].Err, ShouldBeNil)
+			So(hr.fetched["http://local.link/broken"].Status, ShouldEqual, 0)
+			So(hr.fetched["http://local.link/broken"].Err, ShouldNotBeNil)
+		})
+
+		Convey("Check that failures and skipped pages are reported", func() {
+			So(hr.errors, ShouldResemble, []string{"http://local.link/broken: connection refused"})
+			So(len(hr.skipped), ShouldEqual, 2)
+			sort.Strings(hr.skipped)
+			So(hr.skipped[0], ShouldStartWith, "http://local.link/a")
+			So(hr.skipped[0], ShouldContainSubstring, ": duplicate of http://local.link/a")
+			So(hr.skipped[1], ShouldEqual, "http://local.link/img.png: not html")
+		})
+
+		Convey("Check that hooks can be combined", func() {
+			var first, second hookRecorder
+			hooks := MultiHooks(first.hooks(), nil, second.hooks())
+			_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Hooks: hooks})
+			So(err, ShouldBeNil)
+			So(len(first.started), ShouldEqual, 5)
+			So(len(second.started), ShouldEqual, 5)
+			So(first.errors, ShouldResemble, second.errors)
+		})
+
+		Convey("Check that the parsed pages are reported", func() {
+			So(len(hr.parsed), ShouldBeBetweenOrEqual, 2, 3)
+			So(strings.Join(hr.parsed, " "), ShouldContainSubstring, "http://local.link/ ")
+		})
+	})
+}
@@ -0,0 +1,511 @@
+package crawler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Returned when a job is submitted to a full queue.
+var ErrQueueFull = errors.New("the job queue is full")
+
+// Returned when a job ID is not known.
+var ErrNoJob = errors.New("no such job")
+
+// Returned when cancelling a job which has already finished.
+var ErrJobFinished = errors.New("the job has already finished")
+
+// Returned when asking for the results of a job without any.
+var ErrNoResults = errors.New("the job has no results")
+
+/**
+ * The states a job passes through.
+ */
+type JobState string
+
+const (
+	JobState_Queued    JobState = "queued"
+	JobState_Running   JobState = "running"
+	JobState_Done      JobState = "done"
+	JobState_Failed    JobState = "failed"
+	JobState_Cancelled JobState = "cancelled"
+)
+
+/**
+ * Report whether a job in this state has finished.
+ */
+func (s JobState) IsFinished() bool {
+	return s == JobState_Done || s == JobState_Failed || s == JobState_Cancelled
+}
+
+/**
+ * This struct describes a crawl to run as a job.
+ */
+type JobRequest struct {
+	Seed string `json:"seed"`
+	// Links outside of the scope are remote. Defaults to the seed.
+	Scope string `json:"scope,omitempty"`
+	// The most pages fetched at once. Zero means no limit.
+	Workers int `json:"workers,omitempty"`
+	// The time to leave between starting fetches, such as "500ms".
+	Delay string `json:"delay,omitempty"`
+}
+
+/*
+ * Check the request and return the site it crawls.
+ */
+func (r *JobRequest) site() (*Site, error) {
+	site := new(Site)
+
+	seed, err := url.Parse(r.Seed)
+	if err != nil || (seed.Scheme != "http" && seed.Scheme != "https") || seed.Host == "" {
+		return nil, errors.New("seed must be an http or https URL")
+	}
+	site.Seeds = []*url.URL{seed}
+
+	if r.Scope != "" {
+		site.Scope, err = url.Parse(r.Scope)
+		if err != nil || !site.Scope.IsAbs() {
+			return nil, errors.New("scope must be an absolute URL")
+		}
+	}
+	if r.Workers < 0 {
+		return nil, errors.New("workers cannot be negative")
+	}
+	if r.Delay != "" {
+		site.Delay, err = time.ParseDuration(r.Delay)
+		if err != nil || site.Delay < 0 {
+			return nil, errors.New("delay must be a duration such as 500ms")
+		}
+	}
+
+	return site, nil
+}
+
+/**
+ * This struct describes a job and its progress.
+ */
+type Job struct {
+	ID       string     `json:"id"`
+	Request  JobRequest `json:"request"`
+	State    JobState   `json:"state"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// The pages processed so far, and how many of them are broken.
+	Pages  int `json:"pages"`
+	Broken int `json:"broken"`
+}
+
+/*
+ * A job held by the queue, with the means to cancel it while it runs.
+ */
+type queuedJob struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+/**
+ * A JobQueue runs crawl jobs in the background, a few at a time. Jobs
+ * wait in a queue of limited size for their turn. The state of each job
+ * and the crawl it produces are kept in a directory of their own, so that
+ * finished jobs are still available when the queue is opened again.
+ */
+type JobQueue struct {
+	// If set before jobs are submitted, the crawl of every job calls
+	// these hooks.
+	Hooks *Hooks
+	// If set before jobs are submitted, jobs log to it as they start and
+	// finish, and their crawls log to it as well.
+	Logger *slog.Logger
+	// If set before jobs are submitted, the crawl of every job retries
+	// failed fetches as it says.
+	Retry *RetryPolicy
+
+	sync.Mutex
+	dir     string
+	fetcher Fetcher
+	jobs    map[string]*queuedJob
+	// The jobs waiting to run, oldest first, and the most there may be.
+	pending []*queuedJob
+	size    int
+	// Signalled when a job is queued, to wake a worker.
+	wake chan struct{}
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+/**
+ * Open a job queue keeping its jobs in dir, holding at most size jobs
+ * waiting to run and running up to workers at once. Jobs fetch with
+ * fetcher, or a client made with DefaultClientOptions if it is nil. Jobs
+ * left queued or running by a previous queue are marked as failed.
+ */
+func NewJobQueue(dir string, size int, workers int, fetcher Fetcher) (*JobQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if fetcher == nil {
+		fetcher = defaultClient
+	}
+
+	q := new(JobQueue)
+	q.dir = dir
+	q.fetcher = fetcher
+	q.jobs = make(map[string]*queuedJob)
+	q.size = size
+	q.wake = make(chan struct{}, size)
+	q.ctx, q.stop = context.WithCancel(context.Background())
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "job.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		qj := new(queuedJob)
+		if err := json.Unmarshal(data, &qj.job); err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+		if !qj.job.State.IsFinished() {
+			qj.job.State = JobState_Failed
+			qj.job.Error = "interrupted"
+			if err := q.save(&qj.job); err != nil {
+				return nil, err
+			}
+		}
+		q.jobs[qj.job.ID] = qj
+	}
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	return q, nil
+}
+
+/*
+ * Write the state of a job to its directory.
+ */
+func (q *JobQueue) save(job *Job) error {
+	dir := filepath.Join(q.dir, job.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so the state is never half written.
+	tmp := filepath.Join(dir, "job.json.tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, "job.json"))
+}
+
+/*
+ * Return a new random job ID.
+ */
+func newJobID() (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id[:]), nil
+}
+
+/**
+ * Queue a job. An error is returned if the request is invalid or the
+ * queue is full, in which case it is ErrQueueFull.
+ */
+func (q *JobQueue) Submit(req JobRequest) (Job, error) {
+	if _, err := req.site(); err != nil {
+		return Job{}, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.pending) >= q.size {
+		return Job{}, ErrQueueFull
+	}
+
+	// The job is only queued once it is saved, so that a job which is
+	// reported as not submitted never runs.
+	qj := &queuedJob{job: Job{ID: id, Request: req, State: JobState_Queued, Created: time.Now()}}
+	if err := q.save(&qj.job); err != nil {
+		os.RemoveAll(filepath.Join(q.dir, id))
+		return Job{}, err
+	}
+
+	q.jobs[id] = qj
+	q.pending = append(q.pending, qj)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return qj.job, nil
+}
+
+/**
+ * Return a job by its ID.
+ */
+func (q *JobQueue) Get(id string) (Job, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	qj, exists := q.jobs[id]
+	if !exists {
+		return Job{}, ErrNoJob
+	}
+
+	return qj.job, nil
+}
+
+/**
+ * Return every job, oldest first.
+ */
+func (q *JobQueue) Jobs() []Job {
+	q.Lock()
+	defer q.Unlock()
+
+	jobs := []Job{}
+	for _, qj := range q.jobs {
+		jobs = append(jobs, qj.job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].Created.Equal(jobs[j].Created) {
+			return jobs[i].Created.Before(jobs[j].Created)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs
+}
+
+/**
+ * Cancel a job. A queued job is cancelled at once; a running job stops
+ * fetching pages and is cancelled once the pages being fetched are done.
+ */
+func (q *JobQueue) Cancel(id string) (Job, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	qj, exists := q.jobs[id]
+	if !exists {
+		return Job{}, ErrNoJob
+	}
+	if qj.job.State.IsFinished() {
+		return qj.job, ErrJobFinished
+	}
+
+	if qj.job.State == JobState_Queued {
+		// Take the job out of the queue so that it no longer takes up room.
+		for i, pending := range q.pending {
+			if pending == qj {
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+		now := time.Now()
+		qj.job.State = JobState_Cancelled
+		qj.job.Finished = &now
+		if err := q.save(&qj.job); err != nil {
+			return qj.job, err
+		}
+	} else {
+		qj.cancel()
+	}
+
+	return qj.job, nil
+}
+
+/**
+ * Return the crawl made by a finished job, or ErrNoResults if it did not
+ * make one.
+ */
+func (q *JobQueue) Results(id string) (*Page, error) {
+	job, err := q.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !job.State.IsFinished() {
+		return nil, ErrNoResults
+	}
+
+	f, err := os.Open(filepath.Join(q.dir, id, "crawl.json"))
+	if os.IsNotExist(err) {
+		return nil, ErrNoResults
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadJSON(f)
+}
+
+/**
+ * Stop the queue, cancelling the running jobs and waiting for them to
+ * finish. Jobs still queued are left queued.
+ */
+func (q *JobQueue) Close() {
+	q.stop()
+	q.wg.Wait()
+}
+
+/*
+ * Run queued jobs until the queue is closed.
+ */
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.wake:
+			// Run jobs until none are left, in case the wake up of one was
+			// dropped because enough were already waiting.
+			for q.ctx.Err() == nil {
+				qj := q.next()
+				if qj == nil {
+					break
+				}
+				q.run(qj)
+			}
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+ * Take the oldest job from the queue, or return nil if it is empty.
+ */
+func (q *JobQueue) next() *queuedJob {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil
+	}
+	qj := q.pending[0]
+	q.pending = q.pending[1:]
+
+	return qj
+}
+
+/*
+ * Run a job, unless it was cancelled while it was queued.
+ */
+func (q *JobQueue) run(qj *queuedJob) {
+	q.Lock()
+	if qj.job.State != JobState_Queued {
+		q.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	now := time.Now()
+	qj.cancel = cancel
+	qj.job.State = JobState_Running
+	qj.job.Started = &now
+	err := q.save(&qj.job)
+	req := qj.job.Request
+	hooks := q.Hooks
+	retry := q.Retry
+	logger := discardLogger
+	if q.Logger != nil {
+		logger = q.Logger.With("job", qj.job.ID)
+	}
+	q.Unlock()
+
+	logger.Info("job started", "seed", req.Seed)
+
+	var root *Page
+	if err == nil {
+		site, _ := req.site()
+		opts := &Options{Fetcher: q.fetcher, Workers: req.Workers, Context: ctx, Hooks: hooks, Logger: logger, Retry: retry}
+		opts.OnPage = func(p *Page) {
+			q.Lock()
+			defer q.Unlock()
+			qj.job.Pages++
+			if p.IsBroken() {
+				qj.job.Broken++
+			}
+		}
+
+		var results []*SiteResult
+		results, err = ProcessSites([]*Site{site}, opts)
+		if err == nil {
+			root, err = results[0].Pages[0], results[0].Err
+		}
+	}
+
+	if root != nil && ctx.Err() == nil {
+		Analyse(root, DefaultDamping, DefaultIterations)
+		if saveErr := q.saveResults(qj.job.ID, root); err == nil {
+			err = saveErr
+		}
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	finished := time.Now()
+	qj.job.Finished = &finished
+	if ctx.Err() != nil {
+		qj.job.State = JobState_Cancelled
+	} else if err != nil {
+		qj.job.State = JobState_Failed
+		qj.job.Error = err.Error()
+	} else {
+		qj.job.State = JobState_Done
+	}
+	logger.Info("job finished", "state", qj.job.State, "pages", qj.job.Pages, "duration", finished.Sub(now))
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("job failed", "error", err)
+	}
+	if err := q.save(&qj.job); err != nil {
+		logger.Error("unable to save job", "error", err)
+	}
+}
+
+/*
+ * Write the crawl made by a job to its directory.
+ */
+func (q *JobQueue) saveResults(id string, root *Page) error {
+	f, err := os.Create(filepath.Join(q.dir, id, "crawl.json"))
+	if err != nil {
+		return err
+	}
+
+	if err := WriteJSON(f, root); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
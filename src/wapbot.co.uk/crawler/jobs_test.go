@@ -0,0 +1,238 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+ * A fetcher which holds every request until it is released or the
+ * request is cancelled, signalling each request as it starts.
+ */
+type blockingFetcher struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingFetcher() *blockingFetcher {
+	return &blockingFetcher{make(chan string, 100), make(chan struct{})}
+}
+
+func (bf *blockingFetcher) Do(req *http.Request) (*http.Response, error) {
+	bf.started <- req.URL.String()
+	select {
+	case <-bf.release:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	resp := new(http.Response)
+	resp.StatusCode = 200
+	resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+	resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Held</title></head></html>`)}
+	return resp, nil
+}
+
+/*
+ * Wait for a job to finish, giving up after a few seconds.
+ */
+func waitForJob(q *JobQueue, id string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := q.Get(id)
+		if err != nil || job.State.IsFinished() || time.Now().After(deadline) {
+			return job
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_JobQueue(t *testing.T) {
+	Convey("Given a job queue", t, func() {
+		dir := t.TempDir()
+
+		Convey("Check that a job is run and its results kept", func() {
+			q, err := NewJobQueue(dir, 4, 2, newSiteFetcher())
+			So(err, ShouldBeNil)
+			defer q.Close()
+
+			job, err := q.Submit(JobRequest{Seed: "http://one.link/", Workers: 2})
+			So(err, ShouldBeNil)
+			So(job.ID, ShouldNotBeEmpty)
+			So(job.State, ShouldEqual, JobState_Queued)
+
+			job = waitForJob(q, job.ID)
+			So(job.State, ShouldEqual, JobState_Done)
+			So(job.Error, ShouldBeEmpty)
+			So(job.Pages, ShouldEqual, 5)
+			So(job.Broken, ShouldEqual, 0)
+			So(job.Started, ShouldNotBeNil)
+			So(job.Finished, ShouldNotBeNil)
+			So(job.Finished.Before(*job.Started), ShouldBeFalse)
+
+			root, err := q.Results(job.ID)
+			So(err, ShouldBeNil)
+			So(root.URI, ShouldEqual, "http://one.link/")
+			So(len(root.Pages), ShouldEqual, 4)
+			So(root.PageRank, ShouldBeGreaterThan, 0)
+
+			Convey("And check that it is still there when the queue is opened again", func() {
+				q.Close()
+				reopened, err := NewJobQueue(dir, 4, 2, nil)
+				So(err, ShouldBeNil)
+				defer reopened.Close()
+
+				jobs := reopened.Jobs()
+				So(len(jobs), ShouldEqual, 1)
+				So(jobs[0].ID, ShouldEqual, job.ID)
+				So(jobs[0].State, ShouldEqual, JobState_Done)
+
+				root, err := reopened.Results(job.ID)
+				So(err, ShouldBeNil)
+				So(len(root.Pages), ShouldEqual, 4)
+			})
+		})
+
+		Convey("Check that invalid requests are refused", func() {
+			q, err := NewJobQueue(dir, 4, 1, nil)
+			So(err, ShouldBeNil)
+			defer q.Close()
+
+			_, err = q.Submit(JobRequest{Seed: "ftp://one.link/"})
+			So(err, ShouldNotBeNil)
+			_, err = q.Submit(JobRequest{Seed: "http://one.link/", Delay: "soon"})
+			So(err, ShouldNotBeNil)
+			_, err = q.Submit(JobRequest{Seed: "http://one.link/", Workers: -1})
+			So(err, ShouldNotBeNil)
+			So(q.Jobs(), ShouldBeEmpty)
+
+			_, err = q.Get("missing")
+			So(err, ShouldEqual, ErrNoJob)
+		})
+
+		Convey("Check that the queue is bounded and jobs can be cancelled", func() {
+			fetcher := newBlockingFetcher()
+			q, err := NewJobQueue(dir, 1, 1, fetcher)
+			So(err, ShouldBeNil)
+			defer q.Close()
+
+			running, err := q.Submit(JobRequest{Seed: "http://one.link/"})
+			So(err, ShouldBeNil)
+			So(<-fetcher.started, ShouldEqual, "http://one.link/")
+
+			queued, err := q.Submit(JobRequest{Seed: "http://two.link/"})
+			So(err, ShouldBeNil)
+			_, err = q.Submit(JobRequest{Seed: "http://three.link/"})
+			So(err, ShouldEqual, ErrQueueFull)
+
+			job, err := q.Get(running.ID)
+			So(job.State, ShouldEqual, JobState_Running)
+			_, err = q.Results(running.ID)
+			So(err, ShouldEqual, ErrNoResults)
+
+			job, err = q.Cancel(queued.ID)
+			So(err, ShouldBeNil)
+			So(job.State, ShouldEqual, JobState_Cancelled)
+
+			_, err = q.Cancel(running.ID)
+			So(err, ShouldBeNil)
+			job = waitForJob(q, running.ID)
+			So(job.State, ShouldEqual, JobState_Cancelled)
+			_, err = q.Results(running.ID)
+			So(err, ShouldEqual, ErrNoResults)
+
+			_, err = q.Cancel(running.ID)
+			So(err, ShouldEqual, ErrJobFinished)
+		})
+
+		Convey("Check that cancelled jobs no longer take up room in the queue", func() {
+			q, err := NewJobQueue(dir, 1, 0, nil)
+			So(err, ShouldBeNil)
+			defer q.Close()
+
+			cancelled, err := q.Submit(JobRequest{Seed: "http://one.link/"})
+			So(err, ShouldBeNil)
+			_, err = q.Submit(JobRequest{Seed: "http://two.link/"})
+			So(err, ShouldEqual, ErrQueueFull)
+
+			_, err = q.Cancel(cancelled.ID)
+			So(err, ShouldBeNil)
+			_, err = q.Submit(JobRequest{Seed: "http://two.link/"})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Check that a job which cannot be saved is not queued", func() {
+			fetcher := newBlockingFetcher()
+			q, err := NewJobQueue(dir, 1, 1, fetcher)
+			So(err, ShouldBeNil)
+			defer q.Close()
+
+			// Jobs cannot be saved under a file.
+			file := filepath.Join(dir, "file")
+			So(os.WriteFile(file, nil, 0644), ShouldBeNil)
+			q.dir = file
+			_, err = q.Submit(JobRequest{Seed: "http://one.link/"})
+			So(err, ShouldNotBeNil)
+			So(q.Jobs(), ShouldBeEmpty)
+
+			// The queue is not taken up by it either, and nothing runs.
+			q.dir = dir
+			job, err := q.Submit(JobRequest{Seed: "http://two.link/"})
+			So(err, ShouldBeNil)
+			So(<-fetcher.started, ShouldEqual, "http://two.link/")
+			So(len(q.Jobs()), ShouldEqual, 1)
+			q.Cancel(job.ID)
+			waitForJob(q, job.ID)
+		})
+
+		Convey("Check that jobs retry failed fetches with the queue's policy", func() {
+			failed := false
+			fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+				resp := new(http.Response)
+				resp.StatusCode = 200
+				resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+				if !failed {
+					failed = true
+					resp.StatusCode = 503
+				}
+				resp.Body = &openCloseBuffer{bytes.NewBufferString("<html><body>Home</body></html>")}
+				return resp, nil
+			})
+			q, err := NewJobQueue(dir, 1, 1, fetcher)
+			So(err, ShouldBeNil)
+			defer q.Close()
+			q.Retry = &RetryPolicy{MaxAttempts: map[FailureClass]int{FailureClass_ServerError: 2}, BaseDelay: time.Millisecond}
+
+			job, err := q.Submit(JobRequest{Seed: "http://one.link/"})
+			So(err, ShouldBeNil)
+			job = waitForJob(q, job.ID)
+			So(job.State, ShouldEqual, JobState_Done)
+			So(job.Broken, ShouldEqual, 0)
+
+			root, err := q.Results(job.ID)
+			So(err, ShouldBeNil)
+			So(root.Status, ShouldEqual, 200)
+			So(root.Retries, ShouldEqual, 1)
+		})
+
+		Convey("Check that jobs left unfinished are marked as failed", func() {
+			So(os.MkdirAll(filepath.Join(dir, "abc"), 0755), ShouldBeNil)
+			job := `{"id": "abc", "request": {"seed": "http://one.link/"}, "state": "running"}`
+			So(os.WriteFile(filepath.Join(dir, "abc", "job.json"), []byte(job), 0644), ShouldBeNil)
+
+			q, err := NewJobQueue(dir, 1, 1, nil)
+			So(err, ShouldBeNil)
+			defer q.Close()
+
+			loaded, err := q.Get("abc")
+			So(err, ShouldBeNil)
+			So(loaded.State, ShouldEqual, JobState_Failed)
+			So(loaded.Error, ShouldEqual, "interrupted")
+		})
+	})
+}
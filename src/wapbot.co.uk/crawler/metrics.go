@@ -0,0 +1,208 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The upper bounds, in seconds, of the buckets of the duration histograms.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/*
+ * A histogram of durations, counted into durationBuckets.
+ */
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(durationBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+/**
+ * Metrics counts what crawls do, for monitoring, and writes the counts in
+ * the Prometheus text exposition format. Metrics follows crawls through
+ * the hooks returned by Hooks, and the same Metrics may follow several
+ * crawls at once.
+ */
+type Metrics struct {
+	sync.Mutex
+	// Fetches by the class of their response status, such as "2xx", or
+	// "error" if there was no response.
+	fetches map[string]int64
+	// Fetch durations by host.
+	latency map[string]*histogram
+	bytes   int64
+	parse   *histogram
+	// Pages queued but not yet being fetched, and pages being fetched.
+	frontier int64
+	active   int64
+	// Fetches retried.
+	retries int64
+}
+
+/**
+ * Create metrics with every count at zero.
+ */
+func NewMetrics() *Metrics {
+	m := new(Metrics)
+	m.fetches = make(map[string]int64)
+	m.latency = make(map[string]*histogram)
+	m.parse = newHistogram()
+
+	return m
+}
+
+/**
+ * Return the hooks through which a crawl updates the metrics.
+ */
+func (m *Metrics) Hooks() *Hooks {
+	return &Hooks{
+		OnLinkDiscovered: func(from *Page, uri string) {
+			m.Lock()
+			defer m.Unlock()
+			m.frontier++
+		},
+		OnFetchStart: func(uri string) {
+			m.Lock()
+			defer m.Unlock()
+			m.frontier--
+			m.active++
+		},
+		OnFetched: func(e *FetchEvent) {
+			class := "error"
+			if e.Status > 0 {
+				class = fmt.Sprintf("%dxx", e.Status/100)
+			}
+			host := ""
+			if u, err := url.Parse(e.URI); err == nil {
+				host = u.Host
+			}
+
+			m.Lock()
+			defer m.Unlock()
+			m.active--
+			m.fetches[class]++
+			m.bytes += e.Bytes
+			m.retries += int64(e.Retries)
+			if m.latency[host] == nil {
+				m.latency[host] = newHistogram()
+			}
+			m.latency[host].observe(e.Duration)
+		},
+		OnPageParsed: func(page *Page, duration time.Duration) {
+			m.Lock()
+			defer m.Unlock()
+			m.parse.observe(duration)
+		},
+		OnSkipped: func(uri string, reason string) {
+			if Unfetched(reason) {
+				m.Lock()
+				defer m.Unlock()
+				m.frontier--
+			}
+		},
+	}
+}
+
+// Escapes label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+ * Write a metric's help and type lines.
+ */
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+/*
+ * Write the samples of a histogram. Labels, if any, are of the form
+ * `name="value",`.
+ */
+func writeHistogram(w io.Writer, name string, labels string, h *histogram) {
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+/**
+ * Write the metrics in the Prometheus text exposition format.
+ */
+func (m *Metrics) Write(w io.Writer) error {
+	m.Lock()
+	defer m.Unlock()
+	bw := bufio.NewWriter(w)
+
+	writeMetricHeader(bw, "crawler_fetches_total", "counter", "Pages fetched, by the class of the response status or error if there was no response.")
+	var classes []string
+	for class := range m.fetches {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(bw, "crawler_fetches_total{class=\"%s\"} %d\n", class, m.fetches[class])
+	}
+
+	writeMetricHeader(bw, "crawler_fetch_duration_seconds", "histogram", "The time taken to fetch a page, by host.")
+	var hosts []string
+	for host := range m.latency {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		writeHistogram(bw, "crawler_fetch_duration_seconds", "host=\""+labelEscaper.Replace(host)+"\",", m.latency[host])
+	}
+
+	writeMetricHeader(bw, "crawler_downloaded_bytes_total", "counter", "Bytes of page bodies downloaded.")
+	fmt.Fprintf(bw, "crawler_downloaded_bytes_total %d\n", m.bytes)
+
+	writeMetricHeader(bw, "crawler_parse_duration_seconds", "histogram", "The time taken to parse a page.")
+	writeHistogram(bw, "crawler_parse_duration_seconds", "", m.parse)
+
+	writeMetricHeader(bw, "crawler_frontier_size", "gauge", "Pages queued for fetching which are not yet being fetched.")
+	fmt.Fprintf(bw, "crawler_frontier_size %d\n", m.frontier)
+
+	writeMetricHeader(bw, "crawler_active_workers", "gauge", "Pages being fetched.")
+	fmt.Fprintf(bw, "crawler_active_workers %d\n", m.active)
+
+	writeMetricHeader(bw, "crawler_retries_total", "counter", "Fetches retried after a failure.")
+	fmt.Fprintf(bw, "crawler_retries_total %d\n", m.retries)
+
+	return bw.Flush()
+}
+
+/**
+ * Serve the metrics to Prometheus.
+ */
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
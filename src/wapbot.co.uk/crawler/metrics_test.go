@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+ * Return the value of a sample in the exposition format, or -1 if there
+ * is no such sample.
+ */
+func sampleValue(text string, sample string) float64 {
+	re := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(sample) + ` (\S+)$`)
+	match := re.FindStringSubmatch(text)
+	if match == nil {
+		return -1
+	}
+	value, _ := strconv.ParseFloat(match[1], 64)
+	return value
+}
+
+func Test_Metrics(t *testing.T) {
+	Convey("Given the metrics of a crawl", t, func() {
+		metrics := NewMetrics()
+		u, _ := url.Parse("http://one.link/")
+		_, err := ProcessPageWithOptions(u, &Options{Fetcher: newSiteFetcher(), Hooks: metrics.Hooks()})
+		So(err, ShouldBeNil)
+
+		var buf bytes.Buffer
+		So(metrics.Write(&buf), ShouldBeNil)
+		text := buf.String()
+
+		Convey("Check that the fetches are counted", func() {
+			So(text, ShouldContainSubstring, "# TYPE crawler_fetches_total counter\n")
+			So(sampleValue(text, `crawler_fetches_total{class="2xx"}`), ShouldEqual, 5)
+			So(sampleValue(text, "crawler_downloaded_bytes_total"), ShouldBeGreaterThan, 5*100)
+			So(sampleValue(text, "crawler_retries_total"), ShouldEqual, 0)
+		})
+
+		Convey("Check that the durations are counted", func() {
+			So(text, ShouldContainSubstring, "# TYPE crawler_fetch_duration_seconds histogram\n")
+			So(sampleValue(text, `crawler_fetch_duration_seconds_count{host="one.link"}`), ShouldEqual, 5)
+			So(sampleValue(text, `crawler_fetch_duration_seconds_bucket{host="one.link",le="+Inf"}`), ShouldEqual, 5)
+			So(sampleValue(text, `crawler_fetch_duration_seconds_bucket{host="one.link",le="0.001"}`), ShouldEqual, -1)
+			So(sampleValue(text, `crawler_fetch_duration_seconds_sum{host="one.link"}`), ShouldBeGreaterThanOrEqualTo, 5*0.005)
+			So(sampleValue(text, "crawler_parse_duration_seconds_count"), ShouldEqual, 5)
+		})
+
+		Convey("Check that the gauges are back to zero once the crawl is done", func() {
+			So(sampleValue(text, "crawler_frontier_size"), ShouldEqual, 0)
+			So(sampleValue(text, "crawler_active_workers"), ShouldEqual, 0)
+		})
+
+		Convey("Check that failed fetches and the gauges are counted while a crawl runs", func() {
+			hooks := metrics.Hooks()
+			hooks.OnLinkDiscovered(nil, "http://two.link/")
+			hooks.OnLinkDiscovered(nil, "http://two.link/a")
+			hooks.OnFetchStart("http://two.link/")
+			hooks.OnFetchStart("http://two.link/a")
+			hooks.OnFetched(&FetchEvent{URI: "http://two.link/", Err: errors.New("refused"), Duration: 3 * time.Second})
+
+			buf.Reset()
+			So(metrics.Write(&buf), ShouldBeNil)
+			text := buf.String()
+			So(sampleValue(text, `crawler_fetches_total{class="error"}`), ShouldEqual, 1)
+			So(sampleValue(text, `crawler_fetch_duration_seconds_bucket{host="two.link",le="2.5"}`), ShouldEqual, 0)
+			So(sampleValue(text, `crawler_fetch_duration_seconds_bucket{host="two.link",le="5"}`), ShouldEqual, 1)
+			So(sampleValue(text, "crawler_frontier_size"), ShouldEqual, 0)
+			So(sampleValue(text, "crawler_active_workers"), ShouldEqual, 1)
+		})
+
+		Convey("Check that they are served to Prometheus", func() {
+			rec := httptest.NewRecorder()
+			metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain; version=0.0.4")
+			So(rec.Body.String(), ShouldEqual, text)
+		})
+	})
+
+	Convey("Given the metrics of a crawl which is cancelled", t, func() {
+		var links string
+		for i := 0; i < 20; i++ {
+			links += fmt.Sprintf(`<a href="/%d">%d</a>`, i, i)
+		}
+		fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			resp.Body = &openCloseBuffer{bytes.NewBufferString("<html><body>" + links + "</body></html>")}
+			return resp, nil
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		metrics := NewMetrics()
+		var stopped int64
+		hooks := MultiHooks(metrics.Hooks(), &Hooks{OnSkipped: func(uri string, reason string) {
+			if reason == SkipReason_Stopped {
+				atomic.AddInt64(&stopped, 1)
+			}
+		}})
+
+		// Cancel once the seed has been processed, so that its links are
+		// queued but not fetched.
+		u, _ := url.Parse("http://one.link/")
+		_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Hooks: hooks, Workers: 1, Context: ctx, OnPage: func(p *Page) {
+			if p.URI == "http://one.link/" {
+				cancel()
+			}
+		}})
+		So(err, ShouldEqual, context.Canceled)
+
+		Convey("Check that the pages which were not fetched leave the frontier", func() {
+			var buf bytes.Buffer
+			So(metrics.Write(&buf), ShouldBeNil)
+			text := buf.String()
+			So(atomic.LoadInt64(&stopped), ShouldBeGreaterThan, 0)
+			So(sampleValue(text, "crawler_frontier_size"), ShouldEqual, 0)
+			So(sampleValue(text, "crawler_active_workers"), ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given the metrics of a crawl whose seed cannot be requested", t, func() {
+		metrics := NewMetrics()
+		var events []string
+		hooks := MultiHooks(metrics.Hooks(), &Hooks{
+			OnLinkDiscovered: func(from *Page, uri string) { events = append(events, "discovered") },
+			OnFetchStart:     func(uri string) { events = append(events, "fetch") },
+			OnSkipped:        func(uri string, reason string) { events = append(events, reason) },
+		})
+
+		u := &url.URL{Scheme: "http", Host: "one.link:port", Path: "/"}
+		_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcherFunc(nil), Hooks: hooks})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "invalid port")
+
+		Convey("Check that it is skipped and leaves the frontier", func() {
+			So(len(events), ShouldEqual, 2)
+			So(events[0], ShouldEqual, "discovered")
+			So(events[1], ShouldStartWith, SkipReason_InvalidRequest+": ")
+			So(Unfetched(events[1]), ShouldBeTrue)
+
+			var buf bytes.Buffer
+			So(metrics.Write(&buf), ShouldBeNil)
+			So(sampleValue(buf.String(), "crawler_frontier_size"), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,397 @@
+package crawler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/puerkitobio/goquery"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type resourceKind int
+
+const (
+	resourceKind_Raw resourceKind = iota
+	resourceKind_HTML
+	resourceKind_CSS
+)
+
+// Matches url(...) references and @import rules in CSS.
+var cssURLPattern = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)|@import\s+(['"])([^'"]+)(['"])`)
+
+/**
+ * A Mirror saves the pages and assets of a crawl to a directory so that
+ * the site can be browsed offline. Each file is saved under a directory
+ * named after its host, following the structure of the URL path, and
+ * links inside HTML and CSS are rewritten to relative local paths.
+ *
+ * Pages are saved with a .html extension: "/about" is saved as
+ * "about/index.html". A query string is replaced by a hash of it, so
+ * "/search?q=go" becomes "search/index@<hash>.html". Where two URLs would
+ * be saved to the same file the later one, in URL order, gets a numeric
+ * suffix. Only the pages and assets in the crawl, and the resources their
+ * stylesheets refer to, are mirrored; other links are made absolute.
+ */
+type Mirror struct {
+	Dir string
+	// Fetches the pages and assets. As everything has already been
+	// fetched once by the crawl this is best a CachingFetcher.
+	Fetcher Fetcher
+	// URIs which could not be mirrored, mapped to the reason why.
+	Skipped map[string]string
+
+	kinds map[string]resourceKind
+	paths map[string]string
+}
+
+/**
+ * Create a mirror writing to dir.
+ */
+func NewMirror(dir string, fetcher Fetcher) *Mirror {
+	m := new(Mirror)
+	m.Dir = dir
+	m.Fetcher = fetcher
+	m.Skipped = make(map[string]string)
+
+	return m
+}
+
+/*
+ * Return the URI without its fragment, or an empty string if it cannot
+ * be mirrored.
+ */
+func mirrorKey(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	u.Fragment = ""
+
+	return u.String()
+}
+
+/**
+ * Save the pages reachable from root, and their assets, to the mirror.
+ */
+func (m *Mirror) Save(root *Page) error {
+	m.kinds = make(map[string]resourceKind)
+	m.paths = make(map[string]string)
+
+	root.Walk(func(p *Page) {
+		if p.IsBroken() {
+			return
+		}
+		if key := mirrorKey(p.URI); key != "" {
+			m.kinds[key] = resourceKind_HTML
+		}
+		for _, a := range p.Assets {
+			key := mirrorKey(resolveURI(p.URI, a.URI))
+			if _, exists := m.kinds[key]; key != "" && !exists {
+				if a.Type == AssetType_CSS {
+					m.kinds[key] = resourceKind_CSS
+				} else {
+					m.kinds[key] = resourceKind_Raw
+				}
+			}
+		}
+	})
+
+	m.discoverCSS()
+	m.assignPaths()
+
+	var keys []string
+	for key := range m.kinds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := m.saveResource(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Fetch a resource, returning its body, or an empty body and the reason
+ * if it could not be fetched.
+ */
+func (m *Mirror) fetch(uri string) ([]byte, string) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	resp, err := m.Fetcher.Do(req)
+	if err != nil {
+		return nil, err.Error()
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	return body, ""
+}
+
+/*
+ * Add the resources referred to by the stylesheets, following @import
+ * rules into further stylesheets.
+ */
+func (m *Mirror) discoverCSS() {
+	var queue []string
+	for key, kind := range m.kinds {
+		if kind == resourceKind_CSS {
+			queue = append(queue, key)
+		}
+	}
+	sort.Strings(queue)
+
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+
+		body, reason := m.fetch(key)
+		if reason != "" {
+			continue
+		}
+
+		for _, match := range cssURLPattern.FindAllSubmatch(body, -1) {
+			ref, kind := string(match[2]), resourceKind_Raw
+			if len(match[5]) > 0 {
+				ref, kind = string(match[5]), resourceKind_CSS
+			} else if strings.HasSuffix(strings.ToLower(ref), ".css") {
+				kind = resourceKind_CSS
+			}
+
+			newkey := mirrorKey(resolveURI(key, ref))
+			if _, exists := m.kinds[newkey]; newkey != "" && !exists {
+				m.kinds[newkey] = kind
+				if kind == resourceKind_CSS {
+					queue = append(queue, newkey)
+				}
+			}
+		}
+	}
+}
+
+/*
+ * Return the path a resource would ideally be saved to, relative to the
+ * mirror directory and slash separated, or "" if it cannot be saved.
+ */
+func localPath(uri string, kind resourceKind) string {
+	u, _ := url.Parse(uri)
+
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		if kind == resourceKind_HTML {
+			p += "index.html"
+		} else {
+			p += "index"
+		}
+	} else if kind == resourceKind_HTML {
+		ext := strings.ToLower(path.Ext(p))
+		if ext == "" {
+			p += "/index.html"
+		} else if ext != ".html" && ext != ".htm" {
+			p += ".html"
+		}
+	}
+
+	if u.RawQuery != "" {
+		sum := sha1.Sum([]byte(u.RawQuery))
+		ext := path.Ext(p)
+		p = strings.TrimSuffix(p, ext) + "@" + hex.EncodeToString(sum[:4]) + ext
+	}
+
+	// A host such as ".." would climb out of the mirror directory, so it
+	// cannot be mirrored.
+	host := strings.Replace(u.Host, ":", "_", -1)
+	if host == "" || host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return ""
+	}
+
+	return host + rootedPath(p)
+}
+
+/*
+ * Decide where each resource is saved. URIs are handled in order so that
+ * collisions are always resolved the same way.
+ */
+func (m *Mirror) assignPaths() {
+	var keys []string
+	for key := range m.kinds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	used := make(map[string]bool)
+	for _, key := range keys {
+		p := localPath(key, m.kinds[key])
+		if p == "" {
+			continue
+		}
+		ext := path.Ext(p)
+		for n := 2; used[strings.ToLower(p)]; n++ {
+			p = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(localPath(key, m.kinds[key]), ext), n, ext)
+		}
+		used[strings.ToLower(p)] = true
+		m.paths[key] = p
+	}
+}
+
+/*
+ * Rewrite a reference found in the resource saved at from. References to
+ * mirrored resources become relative paths and all others are made
+ * absolute.
+ */
+func (m *Mirror) rewrite(base string, from string, ref string) string {
+	trimmed := strings.TrimSpace(ref)
+	lower := strings.ToLower(trimmed)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") ||
+		strings.HasPrefix(lower, "javascript:") ||
+		strings.HasPrefix(lower, "mailto:") ||
+		strings.HasPrefix(lower, "data:") {
+		return ref
+	}
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(trimmed)
+	if err != nil {
+		return ref
+	}
+	u := b.ResolveReference(r)
+	fragment := u.Fragment
+	u.Fragment = ""
+
+	to, exists := m.paths[u.String()]
+	if !exists && strings.HasSuffix(u.Path, "/") {
+		to, exists = m.paths[strings.TrimSuffix(u.String(), "/")]
+	} else if !exists {
+		to, exists = m.paths[u.String()+"/"]
+	}
+	if !exists {
+		u.Fragment = fragment
+		return u.String()
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(from)), filepath.FromSlash(to))
+	if err != nil {
+		return ref
+	}
+
+	local := &url.URL{Path: filepath.ToSlash(rel), Fragment: fragment}
+
+	return local.String()
+}
+
+/*
+ * Rewrite the references in a stylesheet.
+ */
+func (m *Mirror) rewriteCSS(base string, from string, css []byte) []byte {
+	return cssURLPattern.ReplaceAllFunc(css, func(match []byte) []byte {
+		sub := cssURLPattern.FindSubmatch(match)
+		if len(sub[5]) > 0 {
+			return []byte("@import " + string(sub[4]) + m.rewrite(base, from, string(sub[5])) + string(sub[6]))
+		}
+		return []byte("url(" + string(sub[1]) + m.rewrite(base, from, string(sub[2])) + string(sub[3]) + ")")
+	})
+}
+
+/*
+ * Rewrite the references in an HTML page.
+ */
+func (m *Mirror) rewriteHTML(base string, from string, body []byte) ([]byte, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]string{
+		"a[href]":       "href",
+		"link[href]":    "href",
+		"area[href]":    "href",
+		"img[src]":      "src",
+		"script[src]":   "src",
+		"iframe[src]":   "src",
+		"source[src]":   "src",
+		"video[poster]": "poster",
+	}
+	for selector, attr := range attrs {
+		doc.Find(selector).Each(func(_ int, sel *goquery.Selection) {
+			ref, _ := sel.Attr(attr)
+			sel.SetAttr(attr, m.rewrite(base, from, ref))
+		})
+	}
+
+	doc.Find("style").Each(func(_ int, sel *goquery.Selection) {
+		sel.SetText(string(m.rewriteCSS(base, from, []byte(sel.Text()))))
+	})
+	doc.Find("[style]").Each(func(_ int, sel *goquery.Selection) {
+		style, _ := sel.Attr("style")
+		sel.SetAttr("style", string(m.rewriteCSS(base, from, []byte(style))))
+	})
+
+	html, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(html), nil
+}
+
+/*
+ * Fetch, rewrite and save a single resource.
+ */
+func (m *Mirror) saveResource(key string) error {
+	from, exists := m.paths[key]
+	file := filepath.Join(m.Dir, filepath.FromSlash(from))
+	if rel, err := filepath.Rel(m.Dir, file); !exists || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		m.Skipped[key] = "cannot be saved inside the mirror directory"
+		return nil
+	}
+
+	body, reason := m.fetch(key)
+	if reason != "" {
+		m.Skipped[key] = reason
+		return nil
+	}
+
+	switch m.kinds[key] {
+	case resourceKind_HTML:
+		rewritten, err := m.rewriteHTML(key, from, body)
+		if err != nil {
+			m.Skipped[key] = err.Error()
+			return nil
+		}
+		body = rewritten
+	case resourceKind_CSS:
+		body = m.rewriteCSS(key, from, body)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, body, 0644)
+}
@@ -0,0 +1,150 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LocalPath(t *testing.T) {
+	Convey("Check that URIs are mapped to local paths", t, func() {
+		So(localPath("http://local.link/", resourceKind_HTML), ShouldEqual, "local.link/index.html")
+		So(localPath("http://local.link", resourceKind_HTML), ShouldEqual, "local.link/index.html")
+		So(localPath("http://local.link/about", resourceKind_HTML), ShouldEqual, "local.link/about/index.html")
+		So(localPath("http://local.link/a/b.html", resourceKind_HTML), ShouldEqual, "local.link/a/b.html")
+		So(localPath("http://local.link/a/b.php", resourceKind_HTML), ShouldEqual, "local.link/a/b.php.html")
+		So(localPath("http://local.link:8080/a.png", resourceKind_Raw), ShouldEqual, "local.link_8080/a.png")
+		So(localPath("http://local.link/search?q=go", resourceKind_HTML), ShouldEqual, "local.link/search/index@1ddfd18f.html")
+	})
+
+	Convey("Check that URIs cannot climb out of the mirror directory", t, func() {
+		So(localPath("http://local.link/a/%2e%2e/%2e%2e/%2e%2e/x", resourceKind_HTML), ShouldEqual, "local.link/x/index.html")
+		So(localPath("http://local.link/../../../etc/x", resourceKind_HTML), ShouldEqual, "local.link/etc/x/index.html")
+		So(localPath("http://../evil.png", resourceKind_Raw), ShouldEqual, "")
+		So(localPath("http://./evil.png", resourceKind_Raw), ShouldEqual, "")
+	})
+}
+
+func Test_Mirror_Traversal(t *testing.T) {
+	Convey("Given a crawl holding URIs with dot segments", t, func() {
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Body = &openCloseBuffer{bytes.NewBufferString("<html><body>owned</body></html>")}
+			return resp, nil
+		})
+
+		root := NewPage("http://local.link/", "Home")
+		root.AddPage(NewPage("http://local.link/a/%2e%2e/%2e%2e/%2e%2e/x", "Up"))
+		root.AddPage(NewPage("http://local.link/../../../etc/x", "Etc"))
+		evil, _ := NewAsset("http://../evil.png", AssetType_IMG)
+		root.AddAsset(evil)
+
+		Convey("Mirror it and check that nothing is written outside the mirror directory", func() {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "a", "b", "mirror")
+			m := NewMirror(dir, site)
+			So(m.Save(root), ShouldBeNil)
+
+			var written []string
+			filepath.Walk(parent, func(p string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					rel, _ := filepath.Rel(dir, p)
+					written = append(written, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+			So(written, ShouldResemble, []string{
+				"local.link/etc/x/index.html",
+				"local.link/index.html",
+				"local.link/x/index.html",
+			})
+			So(m.Skipped["http://../evil.png"], ShouldNotBeEmpty)
+		})
+	})
+}
+
+func Test_Mirror(t *testing.T) {
+	Convey("Given a crawled site", t, func() {
+		files := map[string]string{
+			"/":                `<html><head><title>Home</title><link rel="stylesheet" href="/css/site.css"/></head><body><a href="about">About</a> <a href="about/#team">Team</a> <a href="search?q=a">A</a> <a href="search?q=b">B</a> <a href="page/">Page</a> <a href="page/index.html">Page again</a> <a href="http://remote.link/">Remote</a> <img src="logo.png"/></body></html>`,
+			"/about":           `<html><head><title>About</title></head><body><a href="/">Home</a><div style="background: url('/bg.png')"></div></body></html>`,
+			"/about/":          `<html><head><title>About</title></head><body><a href="/">Home</a><div style="background: url('/bg.png')"></div></body></html>`,
+			"/search":          `<html><head><title>Search</title></head><body></body></html>`,
+			"/page/":           `<html><head><title>Page</title></head><body></body></html>`,
+			"/page/index.html": `<html><head><title>Page</title></head><body></body></html>`,
+			"/css/site.css":    `@import "print.css"; body { background: url(../img/bg.png) }`,
+			"/css/print.css":   `h1 { color: black }`,
+			"/img/bg.png":      `PNG`,
+			"/logo.png":        `PNG`,
+			"/bg.png":          `PNG`,
+		}
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			body, exists := files[req.URL.Path]
+			if !exists {
+				resp.StatusCode = 404
+				resp.Body = &openCloseBuffer{bytes.NewBufferString("")}
+				return resp, nil
+			}
+			resp.StatusCode = 200
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		u, _ := url.Parse("http://local.link/")
+		root, err := ProcessPageWithOptions(u, &Options{Fetcher: site})
+		So(err, ShouldBeNil)
+
+		Convey("Mirror it and check the files and links", func() {
+			dir := t.TempDir()
+			m := NewMirror(dir, site)
+			So(m.Save(root), ShouldBeNil)
+			So(len(m.Skipped), ShouldEqual, 0)
+
+			read := func(name string) string {
+				data, err := os.ReadFile(filepath.Join(dir, "local.link", filepath.FromSlash(name)))
+				So(err, ShouldBeNil)
+				return string(data)
+			}
+
+			index := read("index.html")
+			So(index, ShouldContainSubstring, `href="about/index.html"`)
+			So(index, ShouldContainSubstring, `href="about/index.html#team"`)
+			So(index, ShouldContainSubstring, `href="search/index@`)
+			So(index, ShouldContainSubstring, `href="page/index.html"`)
+			So(index, ShouldContainSubstring, `href="page/index-2.html"`)
+			So(index, ShouldContainSubstring, `href="http://remote.link/"`)
+			So(index, ShouldContainSubstring, `href="css/site.css"`)
+			So(index, ShouldContainSubstring, `src="logo.png"`)
+
+			about := read("about/index.html")
+			So(about, ShouldContainSubstring, `href="../index.html"`)
+			// Only resources known to the crawl are mirrored.
+			So(about, ShouldContainSubstring, `url(&#39;http://local.link/bg.png&#39;)`)
+
+			So(read("css/site.css"), ShouldEqual, `@import "print.css"; body { background: url(../img/bg.png) }`)
+			So(read("css/print.css"), ShouldEqual, `h1 { color: black }`)
+			So(read("img/bg.png"), ShouldEqual, "PNG")
+			_, err := os.Stat(filepath.Join(dir, "local.link", "bg.png"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			searches, _ := filepath.Glob(filepath.Join(dir, "local.link", "search", "index@*.html"))
+			So(len(searches), ShouldEqual, 2)
+
+			Convey("And check that mirroring again gives the same result", func() {
+				again := t.TempDir()
+				So(NewMirror(again, site).Save(root), ShouldBeNil)
+				for _, name := range []string{"index.html", "page/index-2.html", "about/index.html"} {
+					data, err := os.ReadFile(filepath.Join(again, "local.link", filepath.FromSlash(name)))
+					So(err, ShouldBeNil)
+					So(string(data), ShouldEqual, read(name))
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+/**
+ * This struct lists the pages of a crawl which are poorly linked.
+ */
+type OrphanReport struct {
+	// Known pages, from a sitemap or a list, with no internal links to
+	// them. They may not have been crawled at all.
+	Orphans []string
+	// Crawled pages with no internal links from them.
+	DeadEnds []string
+	// Crawled pages which only one other page links to.
+	SingleLink []string
+}
+
+/**
+ * Find the poorly linked pages reachable from root. known is the list of
+ * pages which should be on the site, usually from its sitemap. The root
+ * page is never an orphan or single link page, and broken pages are
+ * never dead ends.
+ */
+func NewOrphanReport(root *Page, known []string) *OrphanReport {
+	report := new(OrphanReport)
+
+	pages := make(map[string]*Page)
+	inbound := make(map[*Page]int)
+	root.Walk(func(p *Page) {
+		pages[CanonicalURI(p.URI)] = p
+		for _, np := range p.Pages {
+			inbound[np]++
+		}
+	})
+
+	reported := make(map[string]bool)
+	for _, uri := range known {
+		key := CanonicalURI(uri)
+		if reported[key] {
+			continue
+		}
+		if p, exists := pages[key]; !exists || (p != root && inbound[p] == 0) {
+			reported[key] = true
+			report.Orphans = append(report.Orphans, uri)
+		}
+	}
+
+	for _, p := range pages {
+		if len(p.Pages) == 0 && !p.IsBroken() {
+			report.DeadEnds = append(report.DeadEnds, p.URI)
+		}
+		if p != root && inbound[p] == 1 {
+			report.SingleLink = append(report.SingleLink, p.URI)
+		}
+	}
+
+	sort.Strings(report.Orphans)
+	sort.Strings(report.DeadEnds)
+	sort.Strings(report.SingleLink)
+
+	return report
+}
+
+/**
+ * Dump the report.
+ */
+func (r *OrphanReport) Dump() {
+	var buf bytes.Buffer
+	r.DumpToBuffer(&buf)
+	fmt.Print(buf.String())
+}
+
+/**
+ * Dump the report to a buffer.
+ */
+func (r *OrphanReport) DumpToBuffer(buf *bytes.Buffer) {
+	sections := []struct {
+		name string
+		uris []string
+	}{
+		{"Orphans", r.Orphans},
+		{"Dead ends", r.DeadEnds},
+		{"Single link", r.SingleLink},
+	}
+
+	for _, s := range sections {
+		fmt.Fprintf(buf, "%s: %d\n", s.name, len(s.uris))
+		for _, uri := range s.uris {
+			fmt.Fprintf(buf, "%sURI: %s\n", indent(1), uri)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_OrphanReport(t *testing.T) {
+	Convey("Given a crawl and a list of known pages", t, func() {
+		root := NewPage("http://local.link/", "Home")
+		a := NewPage("http://local.link/a", "A")
+		b := NewPage("http://local.link/b", "B")
+		c := NewPage("http://local.link/c", "C")
+		missing := NewPage("http://local.link/missing", "")
+		missing.Status = 404
+		root.AddPage(a)
+		root.AddPage(b)
+		a.AddPage(b)
+		a.AddPage(c)
+		b.AddPage(root)
+		b.AddPage(missing)
+
+		known := []string{
+			"http://local.link",
+			"http://local.link/a/",
+			"http://local.link/hidden",
+			"http://local.link/hidden/",
+		}
+
+		Convey("Check that the pages are grouped correctly", func() {
+			report := NewOrphanReport(root, known)
+			So(report.Orphans, ShouldResemble, []string{"http://local.link/hidden"})
+			So(report.DeadEnds, ShouldResemble, []string{"http://local.link/c"})
+			So(report.SingleLink, ShouldResemble, []string{"http://local.link/a", "http://local.link/c", "http://local.link/missing"})
+
+			Convey("And check that the report is dumped correctly", func() {
+				var buf bytes.Buffer
+				report.DumpToBuffer(&buf)
+				So(buf.String(), ShouldEqual, `Orphans: 1
+ URI: http://local.link/hidden
+Dead ends: 1
+ URI: http://local.link/c
+Single link: 3
+ URI: http://local.link/a
+ URI: http://local.link/c
+ URI: http://local.link/missing
+`)
+			})
+		})
+	})
+}
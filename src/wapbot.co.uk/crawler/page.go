@@ -34,8 +34,25 @@ func getTypeString(at AssetType) string {
  * This struct describes a general asset.
  */
 type Asset struct {
-	URI  string
-	Type AssetType
+	URI  string    `json:"uri"`
+	Type AssetType `json:"type"`
+}
+
+/**
+ * This struct describes a link from a page. The URI is absolute.
+ */
+type Link struct {
+	URI string `json:"uri"`
+	// The text of the anchor, with whitespace collapsed, or the alt text
+	// of its images if it has none.
+	Text  string `json:"text,omitempty"`
+	Rel   string `json:"rel,omitempty"`
+	Title string `json:"title,omitempty"`
+	// The part of the page the link is in: "nav", "header", "footer",
+	// "aside" or "main", or empty if it is in none of them.
+	Location string `json:"location,omitempty"`
+	// Set if the link is to a remote page.
+	Remote bool `json:"remote,omitempty"`
 }
 
 /**
@@ -49,10 +66,37 @@ type Page struct {
 
 	// The title of the page. Usually from <title> tags.
 	Title string
+	// The HTTP status code the page was fetched with.
+	Status int
+	// Set if the page could not be fetched.
+	Error string
+	// The number of times the page was fetched again after a failure.
+	Retries int
+	// The validators returned with the page, used to make conditional
+	// requests when the site is crawled again.
+	ETag         string
+	LastModified string
+	// The SHA-256 digest of the page body.
+	Digest string
+	// How the page has changed since the previous crawl.
+	Change ChangeType
+	// The SHA-256 digest and SimHash of the main text of the page, used
+	// to find duplicate pages. Both are empty if the page has no text.
+	TextDigest string
+	SimHash    uint64
 	// The assets contained within the page
 	Assets      []*Asset
 	Pages       []*Page
 	RemotePages []*Asset
+	// Every link on the page, in document order.
+	Links []*Link
+
+	// Set by Analyse. The click depth from the seed, the number of local
+	// pages linking to and linked from this page, and its PageRank.
+	Depth     int
+	InDegree  int
+	OutDegree int
+	PageRank  float64
 }
 
 func (p *Page) AddAsset(a *Asset) {
@@ -76,6 +120,60 @@ func (p *Page) AddRemotePage(rp *Asset) {
 	p.RemotePages = append(p.RemotePages, rp)
 }
 
+func (p *Page) AddLink(l *Link) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Links = append(p.Links, l)
+}
+
+/**
+ * A page is broken if it could not be fetched or the server returned
+ * an error status.
+ */
+func (p *Page) IsBroken() bool {
+	return p.Error != "" || p.Status >= 400
+}
+
+/**
+ * Call fn once for this page and each local page reachable from it.
+ */
+func (p *Page) Walk(fn func(*Page)) {
+	visited := make(map[*Page]bool)
+	var walk func(*Page)
+	walk = func(p *Page) {
+		visited[p] = true
+		fn(p)
+		for _, np := range p.Pages {
+			if !visited[np] {
+				walk(np)
+			}
+		}
+	}
+	walk(p)
+}
+
+/**
+ * Return the click depth of each local page reachable from this page,
+ * being the fewest links that must be followed to reach it.
+ */
+func (p *Page) Depths() map[*Page]int {
+	depths := map[*Page]int{p: 0}
+	queue := []*Page{p}
+	for len(queue) > 0 {
+		page := queue[0]
+		queue = queue[1:]
+		for _, np := range page.Pages {
+			if _, seen := depths[np]; !seen {
+				depths[np] = depths[page] + 1
+				queue = append(queue, np)
+			}
+		}
+	}
+
+	return depths
+}
+
 /**
  * Dump data about this page and all pages it links to.
  */
@@ -113,6 +211,17 @@ func (p *Page) DumpPage_Indent(buf *bytes.Buffer, level int, visited map[string]
 
 	fmt.Fprintf(buf, "%sTitle: %s\n", indent(level), p.Title)
 	fmt.Fprintf(buf, "%sURI:   %s\n", indent(level), p.URI)
+	if p.Error != "" {
+		fmt.Fprintf(buf, "%sBroken: %s\n", indent(level), p.Error)
+	} else if p.IsBroken() {
+		fmt.Fprintf(buf, "%sBroken: HTTP %d\n", indent(level), p.Status)
+	}
+	if p.Retries > 0 {
+		fmt.Fprintf(buf, "%sRetries: %d\n", indent(level), p.Retries)
+	}
+	if p.PageRank > 0 {
+		fmt.Fprintf(buf, "%sRank:  %.4f (depth %d, %d in, %d out)\n", indent(level), p.PageRank, p.Depth, p.InDegree, p.OutDegree)
+	}
 	if len(p.Assets) > 0 {
 		fmt.Fprintf(buf, "%sAssets:\n", indent(level))
 
@@ -160,3 +160,56 @@ Pages:
 		})
 	})
 }
+
+func Test_DumpPage_Broken(t *testing.T) {
+	Convey("Given a page which could not be fetched", t, func() {
+		page := NewPage("aaaa", "")
+		page.Error = "connection refused"
+
+		Convey("Check that it is dumped as broken", func() {
+			var buf bytes.Buffer
+			page.DumpToBuffer(&buf)
+			So(buf.String(), ShouldEqual, `Title: 
+URI:   aaaa
+Broken: connection refused
+`)
+		})
+	})
+
+	Convey("Given a page which returned an error status", t, func() {
+		page := NewPage("aaaa", "Not Found")
+		page.Status = 404
+
+		Convey("Check that it is dumped as broken", func() {
+			var buf bytes.Buffer
+			page.DumpToBuffer(&buf)
+			So(buf.String(), ShouldEqual, `Title: Not Found
+URI:   aaaa
+Broken: HTTP 404
+`)
+		})
+	})
+}
+
+func Test_Depths(t *testing.T) {
+	Convey("Given pages linked in a loop with a shortcut", t, func() {
+		a := NewPage("aaaa", "A")
+		b := NewPage("bbbb", "B")
+		c := NewPage("cccc", "C")
+		d := NewPage("dddd", "D")
+		a.AddPage(b)
+		b.AddPage(c)
+		c.AddPage(d)
+		d.AddPage(a)
+		a.AddPage(d)
+
+		Convey("Check that each page has its shortest click depth", func() {
+			depths := a.Depths()
+			So(len(depths), ShouldEqual, 4)
+			So(depths[a], ShouldEqual, 0)
+			So(depths[b], ShouldEqual, 1)
+			So(depths[c], ShouldEqual, 2)
+			So(depths[d], ShouldEqual, 1)
+		})
+	})
+}
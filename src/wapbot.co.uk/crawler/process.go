@@ -1,16 +1,67 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"github.com/puerkitobio/goquery"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 )
 
 type httpGetFunction func(string) (*http.Response, error)
 
+// Returned when a linked URI is not an HTML page.
+var errNotHTML = errors.New("not html")
+
+// Used when no logger is given. Its level is too high for any record.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(127)}))
+
+/**
+ * Options controls how ProcessPageWithOptions crawls a site.
+ */
+type Options struct {
+	// If set the crawl is checkpointed to this store as it progresses,
+	// and any crawl previously checkpointed to it is resumed.
+	Store *Store
+	// If set, pages recorded in this store by a previous crawl are fetched
+	// with conditional requests. Pages which have not been modified are
+	// rebuilt from the previous crawl rather than being parsed again.
+	Previous *Store
+	// Performs the HTTP requests. Defaults to a client made with
+	// DefaultClientOptions.
+	Fetcher Fetcher
+	// If set, called with each page as soon as it has been processed,
+	// before the pages it links to are. It may be called from several
+	// goroutines at once.
+	OnPage func(*Page)
+	// If set, called as each page is queued, fetched and parsed.
+	Hooks *Hooks
+	// If set, each fetch, skipped page and error is logged to it.
+	Logger *slog.Logger
+	// If set, failed fetches are retried as it says. A page keeps its
+	// place in the worker pool while it waits to be retried.
+	Retry *RetryPolicy
+	// If set, links whose path and query match it are not followed, such
+	// as the logout links of a site crawled with a session (see
+	// LogoutPattern). Seeds are always fetched.
+	Avoid *regexp.Regexp
+	// The most pages fetched at once, across every site being crawled.
+	// Zero means no limit.
+	Workers int
+	// If set, the crawl stops fetching pages once the context is done and
+	// returns its error. Pages which were not fetched are left out.
+	Context context.Context
+}
+
 /*
  * Define a remote URL as one where:
  *	- The 'uri' is absolute
@@ -50,37 +101,196 @@ func isSameUri(domain *url.URL, uri *url.URL, newuri *url.URL) bool {
 	return false
 }
 
-func doProcessPage(domain *url.URL, uri *url.URL, buf io.ReadCloser, getter httpGetFunction, visited map[string]*Page) (*Page, error) {
+/*
+ * This struct holds the state shared by every goroutine taking part in
+ * a single crawl.
+ */
+type crawl struct {
+	ctx     context.Context
+	domain  *url.URL
+	fetcher Fetcher
+	store   *Store
+	// Pages recorded by a previous crawl, keyed by URI.
+	previous map[string]*PageRecord
+
+	sync.Mutex
+	wg sync.WaitGroup
+	// Pages which have been processed, keyed by URI.
+	visited map[string]*Page
+	// The URIs of the local pages linked to by each processed page.
+	links map[*Page][]string
+	// URIs which have been queued for fetching.
+	queued map[string]bool
+	// The first error returned by the store, if any.
+	err error
+	// Called with each page as it is added.
+	onPage func(*Page)
+	// Called as the crawl progresses. May be nil.
+	hooks *Hooks
+	// Where the crawl logs to. Never nil.
+	log *slog.Logger
+	// How failed fetches are retried, if they are.
+	retry *RetryPolicy
+	// Links not to follow, if set.
+	avoid *regexp.Regexp
+
+	// The worker pool shared with the crawls of other sites, if any.
+	pool pool
+	// Limits the number of pages fetched from this site at once, if set.
+	slots chan struct{}
+	// The time to leave between starting fetches from this site, and when
+	// the next fetch may start.
+	delay time.Duration
+	next  time.Time
+}
+
+func newCrawl(domain *url.URL, fetcher Fetcher, visited map[string]*Page) *crawl {
+	if visited == nil {
+		visited = make(map[string]*Page)
+	}
+
+	c := new(crawl)
+	c.ctx = context.Background()
+	c.log = discardLogger
+	c.domain = domain
+	c.fetcher = fetcher
+	c.visited = visited
+	c.links = make(map[*Page][]string)
+	c.queued = make(map[string]bool)
+
+	return c
+}
+
+/*
+ * Find a visited page, allowing for a trailing slash on either URI.
+ * The caller must hold the lock.
+ */
+func (c *crawl) lookup(uri string) *Page {
+	if page, exists := c.visited[uri]; exists {
+		return page
+	} else if page, exists := c.visited[uri+"/"]; exists {
+		return page
+	} else if page, exists := c.visited[strings.TrimRight(uri, "/")]; exists {
+		return page
+	}
+
+	return nil
+}
+
+/*
+ * Record a store error. Only the first is kept.
+ */
+func (c *crawl) storeError(err error) {
+	if err == nil {
+		return
+	}
+	c.log.Error("unable to checkpoint the crawl", "error", err)
+
+	c.Lock()
+	defer c.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+/*
+ * Parse a page from the body and queue any local pages it links to. The
+ * response the body came from may be nil. Retries is the number of times
+ * the page had to be fetched again.
+ */
+func (c *crawl) processBody(uri *url.URL, buf io.ReadCloser, resp *http.Response, retries int) (*Page, error) {
 	defer buf.Close()
 
 	// Check to see if we've visited this page.
 	uri.Fragment = ""
-	if visited != nil {
-		if page, exists := visited[uri.String()]; exists {
-			// If we have then return it.
-			return page, nil
-		} else if page, exists := visited[uri.String()+"/"]; exists {
-			return page, nil
-		} else if page, exists := visited[strings.TrimRight(uri.String(), "/")]; exists {
-			return page, nil
-		}
+	c.Lock()
+	page := c.lookup(uri.String())
+	c.Unlock()
+	if page != nil {
+		// If we have then return it.
+		return page, nil
 	}
 
 	// Process the new document
-	doc, err := goquery.NewDocumentFromReader(buf)
+	parseStart := time.Now()
+	digest := sha256.New()
+	doc, err := goquery.NewDocumentFromReader(io.TeeReader(buf, digest))
 	if err != nil {
+		c.log.Warn("parse failed", "url", uri.String(), "error", err)
 		return nil, err
 	}
 
 	title := doc.Find("title").Text()
-	page := NewPage(uri.String(), title)
+	page = NewPage(uri.String(), title)
+	page.Digest = hex.EncodeToString(digest.Sum(nil))
+	page.Retries = retries
+	if resp != nil {
+		page.Status = resp.StatusCode
+		page.ETag = resp.Header.Get("ETag")
+		page.LastModified = resp.Header.Get("Last-Modified")
+	}
+	c.setChange(page)
+
+	fingerprint(page, doc)
+	links := c.parse(uri, doc, page)
+	c.hooks.pageParsed(page, time.Since(parseStart))
 
-	if visited != nil {
-		visited[uri.String()] = page
+	return c.add(page, links), nil
+}
+
+// The page regions links are located in, by element and by ARIA role.
+var linkLocations = map[string]string{
+	"nav":    "nav",
+	"header": "header",
+	"footer": "footer",
+	"aside":  "aside",
+	"main":   "main",
+}
+var linkRoles = map[string]string{
+	"navigation":    "nav",
+	"banner":        "header",
+	"contentinfo":   "footer",
+	"complementary": "aside",
+	"main":          "main",
+}
+
+/*
+ * Create a link from an anchor, without its URI.
+ */
+func newLink(sel *goquery.Selection) *Link {
+	link := new(Link)
+	link.Text = strings.Join(strings.Fields(sel.Text()), " ")
+	if link.Text == "" {
+		var alts []string
+		sel.Find("img[alt]").Each(func(_ int, img *goquery.Selection) {
+			alt, _ := img.Attr("alt")
+			alts = append(alts, strings.Fields(alt)...)
+		})
+		link.Text = strings.Join(alts, " ")
 	}
+	link.Rel, _ = sel.Attr("rel")
+	link.Title, _ = sel.Attr("title")
 
-	var wg sync.WaitGroup
-	spawned := 0
+	// The closest enclosing region wins.
+	sel.Parents().EachWithBreak(func(_ int, parent *goquery.Selection) bool {
+		if location, exists := linkLocations[goquery.NodeName(parent)]; exists {
+			link.Location = location
+		} else if role, _ := parent.Attr("role"); linkRoles[role] != "" {
+			link.Location = linkRoles[role]
+		}
+		return link.Location == ""
+	})
+
+	return link
+}
+
+/*
+ * Extract the assets and remote pages from the document into the page
+ * and return the URIs of the local pages it links to.
+ */
+func (c *crawl) parse(uri *url.URL, doc *goquery.Document, page *Page) []string {
+	var links []string
+	seen := make(map[string]bool)
 	doc.Find("a").EachWithBreak(func(_ int, sel *goquery.Selection) bool {
 		href, exists := sel.Attr("href")
 		if exists {
@@ -90,59 +300,34 @@ func doProcessPage(domain *url.URL, uri *url.URL, buf io.ReadCloser, getter http
 			}
 
 			//If this is a link back to the same page then ignore it.
-			if !isSameUri(domain, uri, newuri) {
+			if !isSameUri(c.domain, uri, newuri) {
 
-				if isRemoteLink(domain, newuri) {
+				link := newLink(sel)
+
+				if isRemoteLink(c.domain, newuri) {
 					rpage, err := NewAsset(href, AssetType_HTML)
 					if err == nil {
 						page.AddRemotePage(rpage)
 					}
-				} else {
-					if !newuri.IsAbs() {
-						newuri = domain.ResolveReference(newuri)
-						newuri.Fragment = ""
-					}
-					if newpage, exists := visited[newuri.String()]; exists {
-						page.AddPage(newpage)
-					} else {
-						wg.Add(1)
-						spawned++
-						go func() {
-							defer wg.Done()
-							resp, err := getter(newuri.String())
-							if err != nil {
-								return
-							}
-							if contentType, exists := resp.Header["Content-Type"]; exists {
-								ok := false
-								for _, s := range contentType {
-									if strings.Contains(s, "text/html") {
-										ok = true
-									}
-								}
-								if !ok {
-									return
-								}
-							}
-							newpage, err := doProcessPage(domain, newuri, resp.Body, getter, visited)
-							if err != nil {
-								return
-							} else {
-								page.AddPage(newpage)
-							}
-						}()
+					link.Remote = true
+				}
+				if !newuri.IsAbs() {
+					newuri = c.domain.ResolveReference(newuri)
+				}
+				if !link.Remote {
+					newuri.Fragment = ""
+					if !seen[newuri.String()] {
+						seen[newuri.String()] = true
+						links = append(links, newuri.String())
 					}
 				}
+				link.URI = newuri.String()
+				page.AddLink(link)
 			}
 		}
 
 		return true
 	})
-	wg.Wait()
-
-	if err != nil {
-		return nil, err
-	}
 
 	doc.Find("img").Each(func(_ int, sel *goquery.Selection) {
 		src, exists := sel.Attr("src")
@@ -176,16 +361,497 @@ func doProcessPage(domain *url.URL, uri *url.URL, buf io.ReadCloser, getter http
 		}
 	})
 
+	return links
+}
+
+/*
+ * Register a processed page, checkpoint it and queue the local pages it
+ * links to which have not been seen before. If another goroutine got to
+ * the page first then that page is returned instead.
+ */
+func (c *crawl) add(page *Page, links []string) *Page {
+	c.Lock()
+	if existing := c.lookup(page.URI); existing != nil {
+		c.Unlock()
+		return existing
+	}
+
+	c.visited[page.URI] = page
+	c.links[page] = links
+
+	var discovered, avoided []string
+	for _, link := range links {
+		if !c.queued[link] && c.lookup(link) == nil {
+			c.queued[link] = true
+			if c.avoids(link) {
+				avoided = append(avoided, link)
+			} else {
+				discovered = append(discovered, link)
+			}
+		}
+	}
+	c.Unlock()
+
+	if c.store != nil {
+		c.storeError(c.store.SavePage(NewPageRecord(page, links), discovered))
+	}
+	for _, link := range avoided {
+		reason := "matches the avoid pattern"
+		c.skip(link, reason)
+		if c.store != nil {
+			c.storeError(c.store.SkipPage(link, reason))
+		}
+	}
+	if c.onPage != nil {
+		c.onPage(page)
+	}
+
+	for _, link := range discovered {
+		c.hooks.linkDiscovered(page, link)
+		c.enqueue(link)
+	}
+
+	return page
+}
+
+/*
+ * Set how a page has changed since the previous crawl, if there is one. A
+ * page which failed is unchanged only if it failed the same way before.
+ */
+func (c *crawl) setChange(page *Page) {
+	if c.previous == nil {
+		return
+	}
+
+	if prev, exists := c.previous[page.URI]; !exists {
+		page.Change = ChangeType_New
+	} else if prev.Digest != page.Digest || prev.Error != page.Error {
+		page.Change = ChangeType_Changed
+	} else {
+		page.Change = ChangeType_Unchanged
+	}
+}
+
+/*
+ * Return whether a link should not be followed.
+ */
+func (c *crawl) avoids(link string) bool {
+	if c.avoid == nil {
+		return false
+	}
+	u, err := url.Parse(link)
+
+	return err == nil && c.avoid.MatchString(u.RequestURI())
+}
+
+/*
+ * Fetch and process a local page in a new goroutine.
+ */
+func (c *crawl) enqueue(uri string) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		page, retries, err := c.fetchPage(uri)
+		if err != nil && c.ctx.Err() != nil {
+			// The crawl was stopped, so leave the page in the frontier.
+			c.log.Debug("fetch stopped", "url", uri, "error", err)
+			return
+		}
+		if err != nil && err != errNotHTML {
+			c.hooks.error(uri, err)
+
+			// Keep the failed page so that links to it show as broken.
+			page = NewPage(uri, "")
+			page.Error = err.Error()
+			page.Retries = retries
+			c.setChange(page)
+			page = c.add(page, nil)
+			err = nil
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else if page.URI != uri {
+			// Another form of the same URI was processed first.
+			reason = "duplicate of " + page.URI
+		}
+		if reason != "" {
+			c.skip(uri, reason)
+		}
+		if reason != "" && c.store != nil {
+			c.storeError(c.store.SkipPage(uri, reason))
+		}
+	}()
+}
+
+/*
+ * Report that a URI was left out of the crawl.
+ */
+func (c *crawl) skip(uri string, reason string) {
+	c.log.Info("skipped", "url", uri, "reason", reason)
+	c.hooks.skipped(uri, reason)
+}
+
+/*
+ * Wait for a turn to fetch from the site, respecting the site's delay
+ * and concurrency limit and the shared worker pool. The returned function
+ * ends the turn.
+ */
+func (c *crawl) acquire() func() {
+	// Take a worker before the delay slot, so that fetches waiting for a
+	// worker do not let their slots pass and then start back to back.
+	if c.slots != nil {
+		c.slots <- struct{}{}
+	}
+	c.pool.acquire()
+
+	if c.delay > 0 {
+		c.waitTurn(time.Now())
+	}
+
+	return func() {
+		c.pool.release()
+		if c.slots != nil {
+			<-c.slots
+		}
+	}
+}
+
+/*
+ * Reserve the site's next delay slot no earlier than the given time and
+ * wait for it. Returns the context's error if the crawl is stopped while
+ * waiting.
+ */
+func (c *crawl) waitTurn(earliest time.Time) error {
+	c.Lock()
+	start := c.next
+	if start.Before(earliest) {
+		start = earliest
+	}
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	c.next = start.Add(c.delay)
+	c.Unlock()
+
+	timer := time.NewTimer(time.Until(start))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+
+	// If the wait overran, count the delay from when the fetch really
+	// starts.
+	c.Lock()
+	if next := time.Now().Add(c.delay); next.After(c.next) {
+		c.next = next
+	}
+	c.Unlock()
+
+	return nil
+}
+
+/*
+ * Fetch and process a local page, returning the page and the number of
+ * times it was retried. If the page was recorded by a previous crawl then
+ * the request is made conditional on it having changed.
+ */
+func (c *crawl) fetchPage(uri string) (*Page, int, error) {
+	req, err := http.NewRequestWithContext(c.ctx, "GET", uri, nil)
+	if err != nil {
+		c.hooks.skipped(uri, SkipReason_InvalidRequest+": "+err.Error())
+		return nil, 0, err
+	}
+
+	prev := c.previous[req.URL.String()]
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+
+	release := c.acquire()
+	defer release()
+	if err := c.ctx.Err(); err != nil {
+		c.hooks.skipped(uri, SkipReason_Stopped)
+		return nil, 0, err
+	}
+
+	c.hooks.fetchStart(uri)
+	start := time.Now()
+	resp, body, retries, err := c.downloadWithRetries(req)
+	event := &FetchEvent{URI: uri, Bytes: int64(len(body)), Duration: time.Since(start), Retries: retries}
+	if resp != nil {
+		event.Status = resp.StatusCode
+	}
+	if err != errNotHTML {
+		event.Err = err
+	}
+	c.hooks.fetched(event)
+	if event.Err != nil && c.ctx.Err() == nil {
+		c.log.Warn("fetch failed", "url", uri, "status", event.Status, "duration", event.Duration, "retries", retries, "error", err)
+	} else if event.Err == nil {
+		c.log.Info("fetched", "url", uri, "status", event.Status, "duration", event.Duration, "retries", retries, "bytes", event.Bytes)
+	}
+	if err != nil {
+		return nil, retries, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		page := prev.Page()
+		page.Change = ChangeType_Unchanged
+		page.Retries = retries
+		return c.add(page, prev.Pages), retries, nil
+	}
+
+	page, err := c.processBody(req.URL, io.NopCloser(bytes.NewReader(body)), resp, retries)
+	return page, retries, err
+}
+
+/*
+ * Download a page, retrying as the crawl's retry policy says, and return
+ * the last response, its body and error along with the number of retries.
+ */
+func (c *crawl) downloadWithRetries(req *http.Request) (*http.Response, []byte, int, error) {
+	for retries := 0; ; retries++ {
+		resp, body, err := c.download(req)
+		if c.retry == nil {
+			return resp, body, retries, err
+		}
+
+		class := classifyFailure(resp, err)
+		wait, ok := c.retry.delay(retries+1, class, resp)
+		if !ok {
+			return resp, body, retries, err
+		}
+
+		attrs := []interface{}{"url", req.URL.String(), "failure", class.String(), "delay", wait, "retry", retries + 1}
+		if resp != nil {
+			attrs = append(attrs, "status", resp.StatusCode)
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		c.log.Info("retrying", attrs...)
+
+		// A retry is a fetch like any other, so it waits for the site's
+		// delay as well as the backoff.
+		if c.waitTurn(time.Now().Add(wait)) != nil {
+			return resp, body, retries, err
+		}
+	}
+}
+
+/*
+ * Make a request and read the body of the response, unless it is not
+ * modified or is not HTML. The whole body is read before it is parsed so
+ * that the time taken to download a page can be told apart from the time
+ * taken to parse it.
+ */
+func (c *crawl) download(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.fetcher.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil, nil
+	}
+
+	if contentType, exists := resp.Header["Content-Type"]; exists {
+		ok := false
+		for _, s := range contentType {
+			if strings.Contains(s, "text/html") {
+				ok = true
+			}
+		}
+		if !ok {
+			return resp, nil, errNotHTML
+		}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+/*
+ * Add pages rebuilt from records to the crawl.
+ */
+func (c *crawl) load(records []*PageRecord) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, rec := range records {
+		page := rec.Page()
+		c.visited[page.URI] = page
+		c.links[page] = rec.Pages
+		c.queued[page.URI] = true
+	}
+}
+
+/*
+ * Reload the pages and frontier of a checkpointed crawl and queue the
+ * frontier for fetching.
+ */
+func (c *crawl) resume() error {
+	records, err := c.store.Pages()
+	if err != nil {
+		return err
+	}
+	frontier, err := c.store.Frontier()
+	if err != nil {
+		return err
+	}
+	skipped, err := c.store.Skipped()
+	if err != nil {
+		return err
+	}
+
+	c.load(records)
+	if c.onPage != nil {
+		for _, rec := range records {
+			c.onPage(c.visited[rec.URI])
+		}
+	}
+
+	c.Lock()
+	for uri := range skipped {
+		c.queued[uri] = true
+	}
+	for _, uri := range frontier {
+		c.queued[uri] = true
+	}
+	c.Unlock()
+
+	for _, uri := range frontier {
+		c.hooks.linkDiscovered(nil, uri)
+		c.enqueue(uri)
+	}
+
+	return nil
+}
+
+/*
+ * Wait for all queued pages to complete and then link each page to the
+ * local pages it refers to.
+ */
+func (c *crawl) wait() error {
+	c.wg.Wait()
+
+	c.Lock()
+	defer c.Unlock()
+	for page, links := range c.links {
+		linked := make(map[*Page]bool)
+		for _, link := range links {
+			if np := c.lookup(link); np != nil && np != page && !linked[np] {
+				linked[np] = true
+				page.AddPage(np)
+			}
+		}
+	}
+
+	return c.err
+}
+
+/*
+ * Crawl from the seed URIs and return the page of each seed, which is nil
+ * if the seed could not be crawled, along with the first error. If there
+ * is a store the crawl it holds is resumed; there may only be one seed.
+ */
+func (c *crawl) run(seeds []*url.URL) ([]*Page, error) {
+	var uris []string
+	for _, seed := range seeds {
+		uri := *seed
+		uri.Fragment = ""
+		uris = append(uris, uri.String())
+	}
+
+	if c.store != nil {
+		if err := c.store.SetSeed(uris[0]); err != nil {
+			return nil, err
+		}
+		if err := c.resume(); err != nil {
+			return nil, err
+		}
+	}
+
+	pages := make([]*Page, len(uris))
+	errs := make([]error, len(uris))
+	var wg sync.WaitGroup
+	for i, uri := range uris {
+		c.Lock()
+		pages[i] = c.lookup(uri)
+		c.Unlock()
+		if pages[i] != nil {
+			continue
+		}
+
+		c.hooks.linkDiscovered(nil, uri)
+		wg.Add(1)
+		go func(i int, uri string) {
+			defer wg.Done()
+			pages[i], _, errs[i] = c.fetchPage(uri)
+			if err := errs[i]; err == errNotHTML {
+				c.skip(uri, err.Error())
+			} else if err != nil && c.ctx.Err() == nil {
+				c.hooks.error(uri, err)
+			}
+		}(i, uri)
+	}
+	wg.Wait()
+
+	err := c.wait()
+	if c.ctx.Err() != nil {
+		err = c.ctx.Err()
+	}
+	for _, e := range errs {
+		if err == nil {
+			err = e
+		}
+	}
+
+	return pages, err
+}
+
+func doProcessPage(domain *url.URL, uri *url.URL, buf io.ReadCloser, getter httpGetFunction, visited map[string]*Page) (*Page, error) {
+	c := newCrawl(domain, getterFetcher(getter), visited)
+
+	page, err := c.processBody(uri, buf, nil, 0)
+	if err != nil {
+		c.wg.Wait()
+		return nil, err
+	}
+
+	if err := c.wait(); err != nil {
+		return nil, err
+	}
+
 	return page, nil
 }
 
 func ProcessPage(uri *url.URL) (*Page, error) {
-	visited := make(map[string]*Page)
+	return ProcessPageWithOptions(uri, nil)
+}
 
-	resp, err := http.Get(uri.String())
+/**
+ * Crawl the site rooted at uri using the given options. A nil options
+ * pointer behaves the same as ProcessPage.
+ */
+func ProcessPageWithOptions(uri *url.URL, opts *Options) (*Page, error) {
+	results, err := ProcessSites([]*Site{{Seeds: []*url.URL{uri}}}, opts)
 	if err != nil {
 		return nil, err
 	}
+	if results[0].Err != nil {
+		return nil, results[0].Err
+	}
 
-	return doProcessPage(uri, uri, resp.Body, http.Get, visited)
+	return results[0].Pages[0], nil
 }
@@ -2,10 +2,13 @@ package crawler
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	. "github.com/smartystreets/goconvey/convey"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sync"
 	"testing"
 )
 
@@ -144,6 +147,19 @@ func Test_ProcessPage(t *testing.T) {
 			So(len(page.Pages), ShouldEqual, 2)
 			So(len(page.Assets), ShouldEqual, 0)
 		})
+
+		Convey("Process the page and confirm that links to pages which cannot be fetched are broken", func() {
+			d, _ := url.Parse("http://local.link")
+			u, _ := url.Parse("http://local.link/zzzz")
+			brokenPage := `<html><body><a href="somewhere">a</a><a href="missing">b</a></body></html>`
+			page, err := doProcessPage(d, u, &openCloseBuffer{bytes.NewBufferString(brokenPage)}, newGetter, nil)
+			So(err, ShouldBeNil)
+			So(len(page.Pages), ShouldEqual, 2)
+			So(page.Pages[0].IsBroken(), ShouldBeFalse)
+			So(page.Pages[1].URI, ShouldEqual, "http://local.link/missing")
+			So(page.Pages[1].IsBroken(), ShouldBeTrue)
+			So(page.Pages[1].Error, ShouldEqual, "Invalid url")
+		})
 	})
 
 	Convey("Given an html page with images", t, func() {
@@ -355,3 +371,326 @@ func Test_ProcessPage_SimpleRealPage(t *testing.T) {
 		})
 	})
 }
+
+func Test_ProcessPage_Resume(t *testing.T) {
+	Convey("Given a store holding a crawl that was interrupted", t, func() {
+		store, err := OpenStore(t.TempDir())
+		So(err, ShouldBeNil)
+		defer store.Close()
+
+		So(store.SetSeed("http://local.link/zzzz"), ShouldBeNil)
+		rec := NewPageRecord(NewPage("http://local.link/zzzz", "This is a title"), []string{"http://local.link/yyyy"})
+		So(store.SavePage(rec, []string{"http://local.link/yyyy"}), ShouldBeNil)
+
+		fetched := make(map[string]int)
+		newGetter := func(uri string) (*http.Response, error) {
+			fetched[uri]++
+			if uri == "http://local.link/yyyy" {
+				newpage := `
+												<html>
+																<head>
+																				<title>This is a sub-article</title>
+																</head>
+																<body>
+																				<a href="zzzz">Link to the first page</a>
+																</body>
+												</html>
+								`
+
+				resp := new(http.Response)
+				resp.StatusCode = 200
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(newpage)}
+
+				return resp, nil
+			}
+
+			return nil, errors.New("Url invalid")
+		}
+
+		Convey("Resume the crawl and check only the frontier is fetched", func() {
+			u, _ := url.Parse("http://local.link/zzzz")
+			c := newCrawl(u, getterFetcher(newGetter), nil)
+			c.store = store
+			pages, err := c.run([]*url.URL{u})
+			So(err, ShouldBeNil)
+			So(len(pages), ShouldEqual, 1)
+			page := pages[0]
+			So(page, ShouldNotBeNil)
+			So(page.Title, ShouldEqual, "This is a title")
+			So(fetched["http://local.link/zzzz"], ShouldEqual, 0)
+			So(fetched["http://local.link/yyyy"], ShouldEqual, 1)
+			So(len(page.Pages), ShouldEqual, 1)
+			So(page.Pages[0].Title, ShouldEqual, "This is a sub-article")
+			So(len(page.Pages[0].Pages), ShouldEqual, 1)
+
+			frontier, err := store.Frontier()
+			So(err, ShouldBeNil)
+			So(len(frontier), ShouldEqual, 0)
+
+			records, err := store.Pages()
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 2)
+		})
+
+		Convey("Resuming with a different seed fails", func() {
+			u, _ := url.Parse("http://other.link/")
+			c := newCrawl(u, getterFetcher(newGetter), nil)
+			c.store = store
+			_, err := c.run([]*url.URL{u})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+type fetcherFunc func(*http.Request) (*http.Response, error)
+
+func (f fetcherFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_ProcessPage_Conditional(t *testing.T) {
+	Convey("Given a previous crawl of two pages", t, func() {
+		previous, err := OpenStore(t.TempDir())
+		So(err, ShouldBeNil)
+		defer previous.Close()
+
+		first := NewPage("http://local.link/", "This is a title")
+		first.ETag = `"v1"`
+		So(previous.SavePage(NewPageRecord(first, []string{"http://local.link/yyyy"}), nil), ShouldBeNil)
+		second := NewPage("http://local.link/yyyy", "This is a sub-article")
+		second.LastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+		So(previous.SavePage(NewPageRecord(second, []string{"http://local.link/xxxx"}), nil), ShouldBeNil)
+		gone := NewPage("http://local.link/wwww", "This page has gone")
+		So(previous.SavePage(NewPageRecord(gone, nil), nil), ShouldBeNil)
+
+		headers := make(map[string]http.Header)
+		fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			headers[req.URL.String()] = req.Header
+			resp := new(http.Response)
+			if req.URL.String() == "http://local.link/xxxx" {
+				resp.StatusCode = 200
+				resp.Body = &openCloseBuffer{bytes.NewBufferString("<html><head><title>New page</title></head></html>")}
+			} else if req.URL.String() == "http://local.link/" {
+				resp.StatusCode = 200
+				resp.Header = http.Header{"Etag": []string{`"v2"`}}
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>New title</title></head><body><a href="yyyy">a</a></body></html>`)}
+			} else {
+				resp.StatusCode = 304
+				resp.Body = &openCloseBuffer{bytes.NewBufferString("")}
+			}
+			return resp, nil
+		})
+
+		Convey("Crawl again and check conditional requests were made", func() {
+			u, _ := url.Parse("http://local.link/")
+			page, err := ProcessPageWithOptions(u, &Options{Previous: previous, Fetcher: fetcher})
+			So(err, ShouldBeNil)
+			So(page, ShouldNotBeNil)
+
+			So(headers["http://local.link/"].Get("If-None-Match"), ShouldEqual, `"v1"`)
+			So(headers["http://local.link/yyyy"].Get("If-Modified-Since"), ShouldEqual, "Mon, 02 Jan 2006 15:04:05 GMT")
+			So(headers["http://local.link/xxxx"].Get("If-None-Match"), ShouldEqual, "")
+
+			So(page.Title, ShouldEqual, "New title")
+			So(page.ETag, ShouldEqual, `"v2"`)
+			So(page.Change, ShouldEqual, ChangeType_Changed)
+			So(len(page.Pages), ShouldEqual, 1)
+			So(page.Pages[0].Title, ShouldEqual, "This is a sub-article")
+			So(page.Pages[0].Change, ShouldEqual, ChangeType_Unchanged)
+			So(len(page.Pages[0].Pages), ShouldEqual, 1)
+			So(page.Pages[0].Pages[0].Change, ShouldEqual, ChangeType_New)
+
+			records, err := previous.Pages()
+			So(err, ShouldBeNil)
+			report := NewChangeReport(page, records)
+			So(report.New, ShouldResemble, []string{"http://local.link/xxxx"})
+			So(report.Changed, ShouldResemble, []string{"http://local.link/"})
+			So(report.Unchanged, ShouldResemble, []string{"http://local.link/yyyy"})
+			So(report.Gone, ShouldResemble, []string{"http://local.link/wwww"})
+		})
+
+		Convey("Crawl again with a page failing and check it is changed, not new", func() {
+			failing := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path == "/yyyy" {
+					return nil, errors.New("connection refused")
+				}
+				return fetcher.Do(req)
+			})
+			u, _ := url.Parse("http://local.link/")
+			page, err := ProcessPageWithOptions(u, &Options{Previous: previous, Fetcher: failing})
+			So(err, ShouldBeNil)
+			So(len(page.Pages), ShouldEqual, 1)
+			So(page.Pages[0].Error, ShouldEqual, "connection refused")
+			So(page.Pages[0].Change, ShouldEqual, ChangeType_Changed)
+
+			records, err := previous.Pages()
+			So(err, ShouldBeNil)
+			report := NewChangeReport(page, records)
+			So(report.New, ShouldBeEmpty)
+			So(report.Changed, ShouldResemble, []string{"http://local.link/", "http://local.link/yyyy"})
+		})
+	})
+}
+
+func Test_ProcessPage_Links(t *testing.T) {
+	Convey("Given an html page with local and remote links", t, func() {
+		page := `
+			<html>
+				<body>
+					<a href="/about#team" rel="nofollow">About
+						us</a>
+					<a href="http://remotelink/somewhere" rel="noopener external">Elsewhere</a>
+					<a href="/zzzz">This page</a>
+				</body>
+			</html>
+		`
+
+		Convey("Process the page and check every link is recorded", func() {
+			d, _ := url.Parse("http://local.link/")
+			u, _ := url.Parse("http://local.link/zzzz")
+			getter := func(uri string) (*http.Response, error) {
+				return nil, errors.New("Invalid url")
+			}
+			page, err := doProcessPage(d, u, &openCloseBuffer{bytes.NewBufferString(page)}, getter, nil)
+			So(err, ShouldBeNil)
+			So(page.Links, ShouldResemble, []*Link{
+				{URI: "http://local.link/about", Text: "About us", Rel: "nofollow"},
+				{URI: "http://remotelink/somewhere", Text: "Elsewhere", Rel: "noopener external", Remote: true},
+			})
+		})
+	})
+}
+
+func Test_ProcessPage_LinkContext(t *testing.T) {
+	Convey("Given an html page with links in different regions", t, func() {
+		page := `
+			<html>
+				<body>
+					<div role="navigation"><a href="/a" title="First page">A</a></div>
+					<header><nav><a href="/b"><img src="b.png" alt="Page B"></a></nav></header>
+					<main><p><a href="/c">C</a></p><aside><a href="/d">D</a></aside></main>
+					<div role="contentinfo"><a href="http://remotelink/">Remote</a></div>
+					<a href="/e">E</a>
+				</body>
+			</html>
+		`
+
+		Convey("Process the page and check where each link is", func() {
+			d, _ := url.Parse("http://local.link/")
+			u, _ := url.Parse("http://local.link/zzzz")
+			getter := func(uri string) (*http.Response, error) {
+				return nil, errors.New("Invalid url")
+			}
+			page, err := doProcessPage(d, u, &openCloseBuffer{bytes.NewBufferString(page)}, getter, nil)
+			So(err, ShouldBeNil)
+			So(page.Links, ShouldResemble, []*Link{
+				{URI: "http://local.link/a", Text: "A", Title: "First page", Location: "nav"},
+				{URI: "http://local.link/b", Text: "Page B", Location: "nav"},
+				{URI: "http://local.link/c", Text: "C", Location: "main"},
+				{URI: "http://local.link/d", Text: "D", Location: "aside"},
+				{URI: "http://remotelink/", Text: "Remote", Location: "footer", Remote: true},
+				{URI: "http://local.link/e", Text: "E"},
+			})
+		})
+	})
+}
+
+func Test_ProcessPage_Logging(t *testing.T) {
+	Convey("Given a crawl logged as JSON", t, func() {
+		fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			body := `<html><head><title>Home</title></head><body>
+				<a href="/broken">Broken</a><a href="/img.png">Image</a></body></html>`
+			switch req.URL.Path {
+			case "/broken":
+				return nil, errors.New("connection refused")
+			case "/img.png":
+				resp.Header.Set("Content-Type", "image/png")
+			}
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		u, _ := url.Parse("http://local.link/")
+		_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Logger: logger})
+		So(err, ShouldBeNil)
+
+		records := make(map[string]map[string]interface{})
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var record map[string]interface{}
+			So(json.Unmarshal(line, &record), ShouldBeNil)
+			So(record["site"], ShouldEqual, "http://local.link/")
+			records[record["msg"].(string)+" "+record["url"].(string)] = record
+		}
+
+		Convey("Check that each fetch is logged with its status and duration", func() {
+			So(len(records), ShouldEqual, 4)
+			fetched := records["fetched http://local.link/"]
+			So(fetched, ShouldNotBeNil)
+			So(fetched["level"], ShouldEqual, "INFO")
+			So(fetched["status"], ShouldEqual, 200)
+			So(fetched["duration"], ShouldBeGreaterThan, 0)
+			So(fetched["bytes"], ShouldBeGreaterThan, 0)
+			So(records["fetched http://local.link/img.png"], ShouldNotBeNil)
+		})
+
+		Convey("Check that failures and skipped pages are logged", func() {
+			failed := records["fetch failed http://local.link/broken"]
+			So(failed, ShouldNotBeNil)
+			So(failed["level"], ShouldEqual, "WARN")
+			So(failed["error"], ShouldEqual, "connection refused")
+			skipped := records["skipped http://local.link/img.png"]
+			So(skipped, ShouldNotBeNil)
+			So(skipped["reason"], ShouldEqual, "not html")
+		})
+	})
+}
+
+func Test_ProcessPage_Avoid(t *testing.T) {
+	Convey("Given a site with a logout link", t, func() {
+		var lock sync.Mutex
+		var fetched []string
+		fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			fetched = append(fetched, req.URL.Path)
+			lock.Unlock()
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			body := `<html><head><title>Account</title></head><body>
+				<a href="/settings">Settings</a><a href="/catalog-outlet/">Outlet</a><a href="/account/log-out">Log out</a>
+				<a href="/wp-login.php?action=logout">Log out</a></body></html>`
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		var skipped []string
+		hooks := &Hooks{OnSkipped: func(uri string, reason string) {
+			skipped = append(skipped, uri+" "+reason)
+		}}
+		u, _ := url.Parse("http://local.link/")
+
+		Convey("Check that the logout link is followed by default", func() {
+			_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Hooks: hooks})
+			So(err, ShouldBeNil)
+			So(fetched, ShouldContain, "/account/log-out")
+			So(skipped, ShouldBeEmpty)
+		})
+
+		Convey("Check that links matching the avoid pattern are not fetched", func() {
+			page, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Hooks: hooks, Avoid: LogoutPattern})
+			So(err, ShouldBeNil)
+			So(fetched, ShouldNotContain, "/account/log-out")
+			So(fetched, ShouldContain, "/catalog-outlet/")
+			So(skipped, ShouldResemble, []string{
+				"http://local.link/account/log-out matches the avoid pattern",
+				"http://local.link/wp-login.php?action=logout matches the avoid pattern",
+			})
+			So(len(page.Pages), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Returned by a ReplayFetcher for a URL the archive has no response for.
+var ErrNotArchived = errors.New("not in archive")
+
+/**
+ * A ReplayFetcher serves the responses recorded in WARC files, so that an
+ * archived site can be crawled without network access. The responses are
+ * held in memory. If a URL was captured more than once the last capture
+ * is served.
+ */
+type ReplayFetcher struct {
+	// Response blocks keyed by target URI and by canonical target URI.
+	responses map[string][]byte
+	// The first target URI in the archive.
+	first string
+}
+
+/**
+ * Create a replay fetcher from WARC files. Each path may be a file or a
+ * directory, in which case every .warc and .warc.gz file in it is read.
+ */
+func NewReplayFetcher(paths ...string) (*ReplayFetcher, error) {
+	rf := new(ReplayFetcher)
+	rf.responses = make(map[string][]byte)
+
+	for _, path := range paths {
+		files, err := warcFiles(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if err := rf.load(file); err != nil {
+				return nil, errors.New(file + ": " + err.Error())
+			}
+		}
+	}
+
+	return rf, nil
+}
+
+/*
+ * Return the WARC files at a path, in name order.
+ */
+func warcFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && (strings.HasSuffix(p, ".warc") || strings.HasSuffix(p, ".warc.gz")) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	sort.Strings(files)
+
+	return files, err
+}
+
+/*
+ * Add the response records of a WARC file.
+ */
+func (rf *ReplayFetcher) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	wr, err := NewWARCReader(f)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := wr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		uri := rec.Get("WARC-Target-URI")
+		if rec.Get("WARC-Type") != "response" || uri == "" {
+			continue
+		}
+
+		if rf.first == "" {
+			rf.first = uri
+		}
+		rf.responses[uri] = rec.Block
+		rf.responses[CanonicalURI(uri)] = rec.Block
+	}
+}
+
+/**
+ * Return the URI of the first response in the archive, which is usually
+ * the seed of the crawl that recorded it.
+ */
+func (rf *ReplayFetcher) First() string {
+	return rf.first
+}
+
+func (rf *ReplayFetcher) Do(req *http.Request) (*http.Response, error) {
+	uri := req.URL.String()
+	block, exists := rf.responses[uri]
+	if !exists {
+		block, exists = rf.responses[CanonicalURI(uri)]
+	}
+	if !exists {
+		return nil, ErrNotArchived
+	}
+
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), req)
+}
@@ -0,0 +1,69 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ReplayFetcher(t *testing.T) {
+	Convey("Given a crawl recorded to gzipped WARC files", t, func() {
+		dir := t.TempDir()
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			if req.URL.Path == "/" {
+				resp.StatusCode = 200
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Home</title></head><body><a href="/a">a</a><a href="/b">b</a></body></html>`)}
+			} else if req.URL.Path == "/a" {
+				resp.StatusCode = 200
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Page A</title></head><body><a href="/">home</a><img src="i.png"/></body></html>`)}
+			} else {
+				resp.StatusCode = 404
+				resp.Body = &openCloseBuffer{bytes.NewBufferString(`<html><head><title>Not Found</title></head></html>`)}
+			}
+			return resp, nil
+		})
+
+		w := NewWARCWriter(filepath.Join(dir, "crawl.warc.gz"), 500)
+		u, _ := url.Parse("http://local.link/")
+		live, err := ProcessPageWithOptions(u, &Options{Fetcher: &WARCFetcher{Fetcher: site, Writer: w}})
+		So(err, ShouldBeNil)
+		So(w.Close(), ShouldBeNil)
+
+		files, _ := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+		So(len(files), ShouldBeGreaterThan, 1)
+
+		Convey("Replay the archive and check the crawl is identical", func() {
+			rf, err := NewReplayFetcher(dir)
+			So(err, ShouldBeNil)
+			So(rf.First(), ShouldEqual, "http://local.link/")
+
+			u, _ := url.Parse(rf.First())
+			replayed, err := ProcessPageWithOptions(u, &Options{Fetcher: rf})
+			So(err, ShouldBeNil)
+
+			var liveJSON, replayedJSON bytes.Buffer
+			So(WriteJSON(&liveJSON, live), ShouldBeNil)
+			So(WriteJSON(&replayedJSON, replayed), ShouldBeNil)
+			So(replayedJSON.String(), ShouldEqual, liveJSON.String())
+		})
+
+		Convey("Check that URLs which were not archived are not served", func() {
+			rf, err := NewReplayFetcher(files...)
+			So(err, ShouldBeNil)
+
+			req, _ := http.NewRequest("GET", "http://local.link/c", nil)
+			_, err = rf.Do(req)
+			So(err, ShouldEqual, ErrNotArchived)
+
+			req, _ = http.NewRequest("GET", "http://LOCAL.link/a/", nil)
+			resp, err := rf.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, 200)
+		})
+	})
+}
@@ -0,0 +1,317 @@
+package crawler
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * A page as shown in the report.
+ */
+type reportPage struct {
+	ID       string
+	URI      string
+	Title    string
+	Status   int
+	Error    string
+	Broken   bool
+	Depth    int
+	Change   string
+	ETag     string
+	Modified string
+	Digest   string
+	Assets   []*Asset
+	Outbound []*reportPage
+	Inbound  []*reportPage
+	Remote   []*Asset
+	Links    []*Link
+}
+
+/*
+ * A node of the site tree, which is built from the URL paths of the pages.
+ * A node has no page if nothing was crawled at its path.
+ */
+type reportNode struct {
+	Name     string
+	Page     *reportPage
+	Children []*reportNode
+}
+
+/*
+ * A count of something, for the dashboard.
+ */
+type reportCount struct {
+	Name  string
+	Count int
+}
+
+/*
+ * Everything the report template needs.
+ */
+type reportData struct {
+	Seed     string
+	Pages    []*reportPage
+	Broken   int
+	Assets   int
+	Remote   int
+	MaxDepth int
+	Statuses []reportCount
+	Types    []reportCount
+	Tree     *reportNode
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"type": getTypeString,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Crawl report for {{.Seed}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.2em 0.5em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { background: #f4f4f4; }
+.dashboard { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { border: 1px solid #ccc; border-radius: 4px; padding: 0.5em 1em; min-width: 8em; }
+.card .value { font-size: 1.6em; font-weight: bold; }
+.broken { color: #b00; }
+.detail { display: none; border: 1px solid #ccc; border-radius: 4px; padding: 0 1em 1em; margin-top: 1em; }
+.detail:target { display: block; }
+.tree ul { list-style: none; padding-left: 1.2em; margin: 0; }
+.tree summary { cursor: pointer; }
+#search { width: 100%; padding: 0.3em; margin-bottom: 0.5em; }
+</style>
+</head>
+<body>
+<h1>Crawl report for <a href="{{.Seed}}">{{.Seed}}</a></h1>
+
+<h2>Summary</h2>
+<div class="dashboard">
+<div class="card"><div class="value">{{len .Pages}}</div>Pages</div>
+<div class="card"><div class="value{{if .Broken}} broken{{end}}">{{.Broken}}</div>Broken pages</div>
+<div class="card"><div class="value">{{.Assets}}</div>Assets</div>
+<div class="card"><div class="value">{{.Remote}}</div>Remote links</div>
+<div class="card"><div class="value">{{.MaxDepth}}</div>Maximum depth</div>
+<div class="card">{{range .Statuses}}<div>{{.Name}}: {{.Count}}</div>{{end}}Statuses</div>
+<div class="card">{{range .Types}}<div>{{.Name}}: {{.Count}}</div>{{else}}<div>None</div>{{end}}Asset types</div>
+</div>
+
+<h2>Site tree</h2>
+<div class="tree">
+{{template "node" .Tree}}
+</div>
+
+<h2>Pages</h2>
+<input id="search" type="search" placeholder="Filter pages" oninput="filterPages(this.value)">
+<table id="pages">
+<thead><tr><th>URI</th><th>Title</th><th>Status</th><th>Depth</th><th>Inbound</th><th>Outbound</th><th>Assets</th></tr></thead>
+<tbody>
+{{range .Pages}}<tr{{if .Broken}} class="broken"{{end}}><td><a href="#{{.ID}}">{{.URI}}</a></td><td>{{.Title}}</td><td>{{template "status" .}}</td><td>{{.Depth}}</td><td>{{len .Inbound}}</td><td>{{len .Outbound}}</td><td>{{len .Assets}}</td></tr>
+{{end}}</tbody>
+</table>
+
+{{range .Pages}}<div class="detail" id="{{.ID}}">
+<h2>{{if .Title}}{{.Title}}{{else}}{{.URI}}{{end}}</h2>
+<table>
+<tr><th>URI</th><td><a href="{{.URI}}">{{.URI}}</a></td></tr>
+<tr><th>Status</th><td{{if .Broken}} class="broken"{{end}}>{{template "status" .}}{{if .Error}} ({{.Error}}){{end}}</td></tr>
+<tr><th>Depth</th><td>{{.Depth}}</td></tr>
+{{if .Change}}<tr><th>Change</th><td>{{.Change}}</td></tr>{{end}}
+{{if .ETag}}<tr><th>ETag</th><td>{{.ETag}}</td></tr>{{end}}
+{{if .Modified}}<tr><th>Last modified</th><td>{{.Modified}}</td></tr>{{end}}
+{{if .Digest}}<tr><th>Digest</th><td>{{.Digest}}</td></tr>{{end}}
+</table>
+<h3>Assets ({{len .Assets}})</h3>
+<ul>{{range .Assets}}<li>{{type .Type}}: {{.URI}}</li>{{end}}</ul>
+<h3>Inbound links ({{len .Inbound}})</h3>
+<ul>{{range .Inbound}}<li><a href="#{{.ID}}">{{.URI}}</a></li>{{end}}</ul>
+<h3>Outbound links ({{len .Outbound}})</h3>
+<ul>{{range .Outbound}}<li{{if .Broken}} class="broken"{{end}}><a href="#{{.ID}}">{{.URI}}</a></li>{{end}}</ul>
+<h3>Remote links ({{len .Remote}})</h3>
+<ul>{{range .Remote}}<li><a href="{{.URI}}">{{.URI}}</a></li>{{end}}</ul>
+{{if .Links}}<h3>Link text ({{len .Links}})</h3>
+<table>
+<tr><th>Anchor</th><th>Target</th><th>Rel</th><th>Title</th><th>Location</th></tr>
+{{range .Links}}<tr><td>{{.Text}}</td><td>{{.URI}}{{if .Remote}} (remote){{end}}</td><td>{{.Rel}}</td><td>{{.Title}}</td><td>{{.Location}}</td></tr>
+{{end}}</table>{{end}}
+</div>
+{{end}}
+
+<script>
+function filterPages(text) {
+	text = text.toLowerCase();
+	var rows = document.querySelectorAll("#pages tbody tr");
+	for (var i = 0; i < rows.length; i++) {
+		var match = rows[i].textContent.toLowerCase().indexOf(text) >= 0;
+		rows[i].style.display = match ? "" : "none";
+	}
+}
+</script>
+</body>
+</html>
+{{define "status"}}{{if .Status}}{{.Status}}{{else if .Error}}Error{{else}}-{{end}}{{end}}
+{{define "node"}}<ul>{{range .Children}}<li>{{if .Children}}<details open><summary>{{template "label" .}}</summary>{{template "node" .}}</details>{{else}}{{template "label" .}}{{end}}</li>{{end}}</ul>{{end}}
+{{define "label"}}{{if .Page}}<a href="#{{.Page.ID}}"{{if .Page.Broken}} class="broken"{{end}}>{{.Name}}</a>{{else}}{{.Name}}{{end}}{{end}}
+`))
+
+/*
+ * Add a page to the site tree under its host and path segments.
+ */
+func (n *reportNode) add(rp *reportPage) {
+	u, err := url.Parse(rp.URI)
+	if err != nil {
+		return
+	}
+
+	names := []string{u.Scheme + "://" + u.Host + "/"}
+	for _, seg := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+		if seg != "" {
+			names = append(names, seg+"/")
+		}
+	}
+	if !strings.HasSuffix(u.Path, "/") && len(names) > 1 {
+		names[len(names)-1] = strings.TrimSuffix(names[len(names)-1], "/")
+	}
+	if u.RawQuery != "" {
+		names = append(names, "?"+u.RawQuery)
+	}
+
+	node := n
+	for _, name := range names {
+		var child *reportNode
+		for _, c := range node.Children {
+			if strings.TrimSuffix(c.Name, "/") == strings.TrimSuffix(name, "/") {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &reportNode{Name: name}
+			node.Children = append(node.Children, child)
+		}
+		node = child
+	}
+	node.Page = rp
+}
+
+/*
+ * Sort the children of each node by name.
+ */
+func (n *reportNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+/*
+ * Return the counts sorted by name.
+ */
+func sortedCounts(counts map[string]int) []reportCount {
+	var sorted []reportCount
+	for name, count := range counts {
+		sorted = append(sorted, reportCount{name, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+/*
+ * Gather the report data for the pages reachable from root.
+ */
+func newReportData(root *Page) *reportData {
+	data := &reportData{Seed: root.URI, Tree: new(reportNode)}
+
+	var pages []*Page
+	root.Walk(func(p *Page) {
+		pages = append(pages, p)
+	})
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URI < pages[j].URI
+	})
+
+	depths := root.Depths()
+	byPage := make(map[*Page]*reportPage)
+	for i, p := range pages {
+		rp := &reportPage{
+			ID:       "page-" + strconv.Itoa(i),
+			URI:      p.URI,
+			Title:    p.Title,
+			Status:   p.Status,
+			Error:    p.Error,
+			Broken:   p.IsBroken(),
+			Depth:    depths[p],
+			ETag:     p.ETag,
+			Modified: p.LastModified,
+			Digest:   p.Digest,
+			Assets:   p.Assets,
+			Remote:   p.RemotePages,
+			Links:    p.Links,
+		}
+		if p.Change != ChangeType_None {
+			rp.Change = getChangeString(p.Change)
+		}
+		byPage[p] = rp
+		data.Pages = append(data.Pages, rp)
+	}
+
+	statuses := make(map[string]int)
+	types := make(map[string]int)
+	assets := make(map[string]bool)
+	for _, p := range pages {
+		rp := byPage[p]
+		for _, np := range p.Pages {
+			rp.Outbound = append(rp.Outbound, byPage[np])
+			byPage[np].Inbound = append(byPage[np].Inbound, rp)
+		}
+		for _, a := range p.Assets {
+			uri := CanonicalURI(resolveURI(p.URI, a.URI))
+			if !assets[uri] {
+				assets[uri] = true
+				types[getTypeString(a.Type)]++
+			}
+		}
+
+		if rp.Broken {
+			data.Broken++
+		}
+		if rp.Depth > data.MaxDepth {
+			data.MaxDepth = rp.Depth
+		}
+		data.Remote += len(p.RemotePages)
+		if p.Status != 0 {
+			statuses[fmt.Sprintf("%d %s", p.Status, http.StatusText(p.Status))]++
+		}
+		data.Tree.add(rp)
+	}
+	data.Assets = len(assets)
+	data.Statuses = sortedCounts(statuses)
+	data.Types = sortedCounts(types)
+	data.Tree.sort()
+
+	return data
+}
+
+/**
+ * Write a self-contained HTML report of the pages reachable from root. The
+ * report has a summary of the crawl, a tree of the site, a searchable
+ * table of the pages and the detail of each page, and needs nothing but a
+ * browser to view.
+ */
+func WriteReport(w io.Writer, root *Page) error {
+	return reportTemplate.Execute(w, newReportData(root))
+}
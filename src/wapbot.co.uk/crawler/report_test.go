@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"bytes"
+	"github.com/puerkitobio/goquery"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_WriteReport(t *testing.T) {
+	Convey("Given a crawl with a broken link", t, func() {
+		root := NewPage("http://local.link/", "Home")
+		root.Status = 200
+		a := NewPage("http://local.link/docs/a", "Page <A>")
+		a.Status = 200
+		missing := NewPage("http://local.link/docs/missing", "")
+		missing.Status = 404
+		root.AddPage(a)
+		a.AddPage(missing)
+		a.AddPage(root)
+		asset, _ := NewAsset("/style.css", AssetType_CSS)
+		root.AddAsset(asset)
+		asset, _ = NewAsset("http://local.link/style.css", AssetType_CSS)
+		a.AddAsset(asset)
+		remote, _ := NewAsset("http://remote.link/", AssetType_HTML)
+		root.AddRemotePage(remote)
+		root.AddLink(&Link{URI: "http://local.link/docs/a", Text: "Read <A>", Rel: "next", Location: "nav"})
+
+		Convey("Check that the report data is gathered", func() {
+			data := newReportData(root)
+			So(len(data.Pages), ShouldEqual, 3)
+			So(data.Broken, ShouldEqual, 1)
+			So(data.Assets, ShouldEqual, 1)
+			So(data.Remote, ShouldEqual, 1)
+			So(data.MaxDepth, ShouldEqual, 2)
+			So(data.Statuses, ShouldResemble, []reportCount{{"200 OK", 2}, {"404 Not Found", 1}})
+			So(data.Types, ShouldResemble, []reportCount{{"CSS", 1}})
+
+			So(data.Pages[1].URI, ShouldEqual, "http://local.link/docs/a")
+			So(len(data.Pages[1].Inbound), ShouldEqual, 1)
+			So(len(data.Pages[1].Outbound), ShouldEqual, 2)
+		})
+
+		Convey("Check that the site tree follows the URL paths", func() {
+			tree := newReportData(root).Tree
+			So(len(tree.Children), ShouldEqual, 1)
+			host := tree.Children[0]
+			So(host.Name, ShouldEqual, "http://local.link/")
+			So(host.Page.URI, ShouldEqual, "http://local.link/")
+			So(len(host.Children), ShouldEqual, 1)
+			docs := host.Children[0]
+			So(docs.Name, ShouldEqual, "docs/")
+			So(docs.Page, ShouldBeNil)
+			So(len(docs.Children), ShouldEqual, 2)
+			So(docs.Children[0].Name, ShouldEqual, "a")
+			So(docs.Children[1].Name, ShouldEqual, "missing")
+			So(docs.Children[1].Page.Broken, ShouldBeTrue)
+		})
+
+		Convey("Check that the report is written as HTML", func() {
+			var buf bytes.Buffer
+			So(WriteReport(&buf, root), ShouldBeNil)
+
+			doc, err := goquery.NewDocumentFromReader(&buf)
+			So(err, ShouldBeNil)
+			So(doc.Find("title").Text(), ShouldEqual, "Crawl report for http://local.link/")
+			So(doc.Find("#pages tbody tr").Length(), ShouldEqual, 3)
+			So(doc.Find("#pages tbody tr.broken").Text(), ShouldContainSubstring, "404")
+			So(doc.Find(".detail").Length(), ShouldEqual, 3)
+			So(doc.Find("#page-1 h2").Text(), ShouldEqual, "Page <A>")
+			So(doc.Find("#page-1 li").Text(), ShouldContainSubstring, "CSS: http://local.link/style.css")
+			So(doc.Find("#page-0 td").Text(), ShouldContainSubstring, "Read <A>http://local.link/docs/anextnav")
+			So(doc.Find(".tree details").Length(), ShouldEqual, 2)
+			So(doc.Find("script").Length(), ShouldEqual, 1)
+			So(doc.Find("link[rel=stylesheet]").Length(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,183 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+/**
+ * The kinds of failed fetch which a RetryPolicy may retry.
+ */
+type FailureClass int
+
+const (
+	// Not a failure, or one which is never retried, such as a 404 or a
+	// host which does not exist.
+	FailureClass_None FailureClass = iota
+	// The request or reading the response timed out.
+	FailureClass_Timeout
+	// The connection was refused, reset or closed early.
+	FailureClass_Connection
+	// The server responded 429 Too Many Requests.
+	FailureClass_TooManyRequests
+	// The server responded with a 5xx status.
+	FailureClass_ServerError
+)
+
+func (fc FailureClass) String() string {
+	switch fc {
+	case FailureClass_Timeout:
+		return "timeout"
+	case FailureClass_Connection:
+		return "connection"
+	case FailureClass_TooManyRequests:
+		return "too many requests"
+	case FailureClass_ServerError:
+		return "server error"
+	}
+
+	return "none"
+}
+
+/**
+ * RetryPolicy controls how failed fetches are retried. Each retry waits
+ * for a delay which starts at BaseDelay and doubles with each retry, up
+ * to MaxDelay, and which is randomised by Jitter. If the server sends a
+ * Retry-After header the wait is at least as long as it asks.
+ */
+type RetryPolicy struct {
+	// The most times a page is fetched, by the class of failure, counting
+	// the first fetch. Classes which are missing, or are given fewer than
+	// two attempts, are not retried.
+	MaxAttempts map[FailureClass]int
+	// Zero MaxDelay means no limit.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// The fraction of each delay, from 0 to 1, which is chosen at random
+	// so that retries from many workers are spread out.
+	Jitter float64
+	// If a Retry-After header asks for a longer wait than this then the
+	// page is not retried. Zero means MaxDelay, or no limit if that is
+	// zero too.
+	MaxRetryAfter time.Duration
+}
+
+/**
+ * A retry policy which tries a page three times after a timeout,
+ * connection failure or server error and five times after a 429. It is
+ * crawlapp's default.
+ */
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: map[FailureClass]int{
+		FailureClass_Timeout:         3,
+		FailureClass_Connection:      3,
+		FailureClass_TooManyRequests: 5,
+		FailureClass_ServerError:     3,
+	},
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  30 * time.Second,
+	Jitter:    0.5,
+}
+
+/*
+ * Classify the outcome of a fetch from its response, which is nil if the
+ * request failed, and the error from making the request or reading the
+ * body.
+ */
+func classifyFailure(resp *http.Response, err error) FailureClass {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return FailureClass_TooManyRequests
+	} else if resp != nil && resp.StatusCode >= 500 && resp.StatusCode < 600 {
+		return FailureClass_ServerError
+	}
+
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, context.Canceled):
+			return FailureClass_None
+		case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+			return FailureClass_Timeout
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return FailureClass_Timeout
+		case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+			errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE),
+			errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return FailureClass_Connection
+		}
+	}
+
+	return FailureClass_None
+}
+
+/*
+ * Return how long the Retry-After header of a response asks to wait,
+ * and whether it asks at all.
+ */
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := when.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+/*
+ * Return how long to wait before the given retry, counting from one, of
+ * a fetch which failed with the given class of failure and response.
+ * Returns false if the fetch should not be retried.
+ */
+func (rp *RetryPolicy) delay(retry int, class FailureClass, resp *http.Response) (time.Duration, bool) {
+	if class == FailureClass_None || retry >= rp.MaxAttempts[class] {
+		return 0, false
+	}
+
+	wait := rp.BaseDelay
+	for i := 1; i < retry && (rp.MaxDelay == 0 || wait < rp.MaxDelay); i++ {
+		wait *= 2
+	}
+	if rp.MaxDelay > 0 && wait > rp.MaxDelay {
+		wait = rp.MaxDelay
+	}
+	if rp.Jitter > 0 && wait > 0 {
+		spread := time.Duration(float64(wait) * rp.Jitter)
+		wait = wait - spread + time.Duration(rand.Int63n(int64(spread)+1))
+	}
+
+	if after, ok := retryAfter(resp, time.Now()); ok {
+		limit := rp.MaxRetryAfter
+		if limit == 0 {
+			limit = rp.MaxDelay
+		}
+		if limit > 0 && after > limit {
+			return 0, false
+		}
+		if after > wait {
+			wait = after
+		}
+	}
+
+	return wait, true
+}
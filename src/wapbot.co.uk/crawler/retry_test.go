@@ -0,0 +1,228 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_ClassifyFailure(t *testing.T) {
+	Convey("Check that failed fetches are classified", t, func() {
+		status := func(code int) *http.Response {
+			return &http.Response{StatusCode: code}
+		}
+		reset := &url.Error{Op: "Get", URL: "http://local.link/", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}
+		timeout := &url.Error{Op: "Get", URL: "http://local.link/", Err: os.ErrDeadlineExceeded}
+
+		So(classifyFailure(status(200), nil), ShouldEqual, FailureClass_None)
+		So(classifyFailure(status(404), nil), ShouldEqual, FailureClass_None)
+		So(classifyFailure(status(429), nil), ShouldEqual, FailureClass_TooManyRequests)
+		So(classifyFailure(status(503), nil), ShouldEqual, FailureClass_ServerError)
+		So(classifyFailure(status(503), errNotHTML), ShouldEqual, FailureClass_ServerError)
+		So(classifyFailure(nil, reset), ShouldEqual, FailureClass_Connection)
+		So(classifyFailure(nil, fmt.Errorf("dial: %w", syscall.ECONNREFUSED)), ShouldEqual, FailureClass_Connection)
+		So(classifyFailure(status(200), errors.New("unexpected EOF")), ShouldEqual, FailureClass_None)
+		So(classifyFailure(nil, timeout), ShouldEqual, FailureClass_Timeout)
+		So(classifyFailure(nil, context.DeadlineExceeded), ShouldEqual, FailureClass_Timeout)
+		So(classifyFailure(nil, context.Canceled), ShouldEqual, FailureClass_None)
+		So(classifyFailure(nil, errors.New("no such host")), ShouldEqual, FailureClass_None)
+	})
+}
+
+func Test_RetryPolicy(t *testing.T) {
+	Convey("Given a retry policy without jitter", t, func() {
+		policy := &RetryPolicy{
+			MaxAttempts: map[FailureClass]int{FailureClass_ServerError: 4, FailureClass_TooManyRequests: 2},
+			BaseDelay:   100 * time.Millisecond,
+			MaxDelay:    350 * time.Millisecond,
+		}
+
+		Convey("Check that the delay doubles up to the limit", func() {
+			for retry, expected := range []time.Duration{100, 200, 350} {
+				wait, ok := policy.delay(retry+1, FailureClass_ServerError, nil)
+				So(ok, ShouldBeTrue)
+				So(wait, ShouldEqual, expected*time.Millisecond)
+			}
+			_, ok := policy.delay(4, FailureClass_ServerError, nil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Check that each class of failure has its own number of attempts", func() {
+			_, ok := policy.delay(1, FailureClass_TooManyRequests, nil)
+			So(ok, ShouldBeTrue)
+			_, ok = policy.delay(2, FailureClass_TooManyRequests, nil)
+			So(ok, ShouldBeFalse)
+			_, ok = policy.delay(1, FailureClass_Timeout, nil)
+			So(ok, ShouldBeFalse)
+			_, ok = policy.delay(1, FailureClass_None, nil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Check that Retry-After is honoured", func() {
+			resp := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": []string{"0"}}}
+			wait, ok := policy.delay(1, FailureClass_TooManyRequests, resp)
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 100*time.Millisecond)
+
+			policy.MaxRetryAfter = time.Hour
+			resp.Header.Set("Retry-After", "2")
+			wait, ok = policy.delay(1, FailureClass_TooManyRequests, resp)
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 2*time.Second)
+
+			resp.Header.Set("Retry-After", time.Now().Add(90*time.Minute).UTC().Format(http.TimeFormat))
+			_, ok = policy.delay(1, FailureClass_TooManyRequests, resp)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Check that Retry-After may be a date", func() {
+			now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+			resp := &http.Response{Header: http.Header{"Retry-After": []string{"Wed, 01 Jan 2020 12:00:30 GMT"}}}
+			wait, ok := retryAfter(resp, now)
+			So(ok, ShouldBeTrue)
+			So(wait, ShouldEqual, 30*time.Second)
+
+			resp.Header.Set("Retry-After", "soon")
+			_, ok = retryAfter(resp, now)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Check that jitter shortens the delay by at most its fraction", func() {
+			policy.Jitter = 0.5
+			for i := 0; i < 100; i++ {
+				wait, _ := policy.delay(2, FailureClass_ServerError, nil)
+				So(wait, ShouldBeBetweenOrEqual, 100*time.Millisecond, 200*time.Millisecond)
+			}
+		})
+	})
+}
+
+func Test_ProcessPage_Retry(t *testing.T) {
+	Convey("Given a site which fails some fetches", t, func() {
+		var lock sync.Mutex
+		attempts := make(map[string]int)
+		var times []time.Time
+		fetcher := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			lock.Lock()
+			attempts[req.URL.Path]++
+			times = append(times, time.Now())
+			attempt := attempts[req.URL.Path]
+			lock.Unlock()
+
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			body := `<html><head><title>Home</title></head><body>
+				<a href="/reset">Reset</a><a href="/missing">Missing</a></body></html>`
+			switch {
+			case req.URL.Path == "/" && attempt < 3:
+				resp.StatusCode = 503
+				resp.Header.Set("Retry-After", "0")
+			case req.URL.Path == "/reset":
+				return nil, &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+			case req.URL.Path == "/missing":
+				resp.StatusCode = 404
+			}
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		u, _ := url.Parse("http://local.link/")
+
+		Convey("Check that nothing is retried without a policy", func() {
+			page, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher})
+			So(err, ShouldBeNil)
+			So(page.Status, ShouldEqual, 503)
+			So(page.Retries, ShouldEqual, 0)
+			So(attempts["/"], ShouldEqual, 1)
+		})
+
+		Convey("Check that failures are retried and the retries recorded", func() {
+			metrics := NewMetrics()
+			policy := &RetryPolicy{
+				MaxAttempts: map[FailureClass]int{FailureClass_ServerError: 3, FailureClass_Connection: 2},
+				BaseDelay:   time.Millisecond,
+				MaxDelay:    10 * time.Millisecond,
+				Jitter:      0.5,
+			}
+			page, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Retry: policy, Hooks: metrics.Hooks()})
+			So(err, ShouldBeNil)
+			So(page.Status, ShouldEqual, 200)
+			So(page.Retries, ShouldEqual, 2)
+			So(attempts["/"], ShouldEqual, 3)
+
+			So(len(page.Pages), ShouldEqual, 2)
+			for _, p := range page.Pages {
+				if p.URI == "http://local.link/reset" {
+					So(p.Error, ShouldContainSubstring, "connection reset")
+					So(p.Retries, ShouldEqual, 1)
+				} else {
+					So(p.Status, ShouldEqual, 404)
+					So(p.Retries, ShouldEqual, 0)
+				}
+			}
+			So(attempts["/reset"], ShouldEqual, 2)
+			So(attempts["/missing"], ShouldEqual, 1)
+			So(metrics.retries, ShouldEqual, 3)
+
+			var buf bytes.Buffer
+			page.DumpToBuffer(&buf)
+			So(buf.String(), ShouldContainSubstring, "Retries: 2\n")
+		})
+
+		Convey("Check that retries through a cache reach the site", func() {
+			cache, err := NewCachingFetcher(t.TempDir(), fetcher, CacheMode_IfFresh)
+			So(err, ShouldBeNil)
+			policy := &RetryPolicy{MaxAttempts: map[FailureClass]int{FailureClass_ServerError: 3}, BaseDelay: time.Millisecond}
+			page, err := ProcessPageWithOptions(u, &Options{Fetcher: cache, Retry: policy})
+			So(err, ShouldBeNil)
+			So(page.Status, ShouldEqual, 200)
+			So(page.Retries, ShouldEqual, 2)
+			So(attempts["/"], ShouldEqual, 3)
+
+			// Only the response which succeeded is kept for the next crawl.
+			cache.Mode = CacheMode_Only
+			page, err = ProcessPageWithOptions(u, &Options{Fetcher: cache, Retry: policy})
+			So(err, ShouldBeNil)
+			So(page.Status, ShouldEqual, 200)
+			So(page.Retries, ShouldEqual, 0)
+		})
+
+		Convey("Check that retries wait for the site's delay", func() {
+			delay := 30 * time.Millisecond
+			policy := &RetryPolicy{MaxAttempts: map[FailureClass]int{FailureClass_ServerError: 3}, BaseDelay: time.Millisecond}
+			results, err := ProcessSites([]*Site{{Seeds: []*url.URL{u}, Delay: delay, Concurrency: 1}}, &Options{Fetcher: fetcher, Retry: policy})
+			So(err, ShouldBeNil)
+			So(results[0].Pages[0].Retries, ShouldEqual, 2)
+
+			// Every fetch from the site, retried or not, is a delay apart.
+			So(len(times), ShouldEqual, 5)
+			for i := 1; i < len(times); i++ {
+				So(times[i].Sub(times[i-1]), ShouldBeGreaterThanOrEqualTo, delay)
+			}
+		})
+
+		Convey("Check that a stopped crawl does not wait to retry", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			policy := &RetryPolicy{MaxAttempts: map[FailureClass]int{FailureClass_ServerError: 3}, BaseDelay: time.Hour}
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}()
+			start := time.Now()
+			_, err := ProcessPageWithOptions(u, &Options{Fetcher: fetcher, Retry: policy, Context: ctx})
+			So(err, ShouldEqual, context.Canceled)
+			So(time.Since(start), ShouldBeLessThan, time.Minute)
+		})
+	})
+}
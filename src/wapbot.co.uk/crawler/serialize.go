@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+/**
+ * This struct is the serialised form of a Page graph. It is what is
+ * written by WriteJSON.
+ */
+type SavedCrawl struct {
+	Seed  string        `json:"seed"`
+	Pages []*PageRecord `json:"pages"`
+}
+
+/**
+ * Flatten the graph of pages reachable from root.
+ */
+func NewSavedCrawl(root *Page) *SavedCrawl {
+	saved := new(SavedCrawl)
+	saved.Seed = root.URI
+
+	root.Walk(func(p *Page) {
+		var links []string
+		for _, np := range p.Pages {
+			links = append(links, np.URI)
+		}
+		saved.Pages = append(saved.Pages, NewPageRecord(p, links))
+	})
+
+	return saved
+}
+
+/**
+ * Rebuild the Page graph and return the seed page.
+ */
+func (s *SavedCrawl) Root() (*Page, error) {
+	return buildGraph(s.Seed, s.Pages)
+}
+
+/*
+ * Link a set of page records together and return the seed page.
+ */
+func buildGraph(seed string, records []*PageRecord) (*Page, error) {
+	c := newCrawl(nil, nil, nil)
+	c.load(records)
+	c.wait()
+
+	if page := c.lookup(seed); page != nil {
+		return page, nil
+	}
+
+	return nil, errors.New("crawl does not contain its seed page " + seed)
+}
+
+/**
+ * Write the graph of pages reachable from root as JSON.
+ */
+func WriteJSON(w io.Writer, root *Page) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(NewSavedCrawl(root))
+}
+
+/**
+ * Read a graph of pages written by WriteJSON and return the seed page.
+ */
+func ReadJSON(r io.Reader) (*Page, error) {
+	saved := new(SavedCrawl)
+	if err := json.NewDecoder(r).Decode(saved); err != nil {
+		return nil, err
+	}
+
+	return saved.Root()
+}
+
+/**
+ * Rebuild the Page graph of the crawl held in the store and return the
+ * seed page.
+ */
+func (s *Store) Root() (*Page, error) {
+	seed, err := s.Seed()
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.Pages()
+	if err != nil {
+		return nil, err
+	}
+
+	return buildGraph(seed, records)
+}
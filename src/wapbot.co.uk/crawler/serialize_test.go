@@ -0,0 +1,44 @@
+package crawler
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_JSON(t *testing.T) {
+	Convey("Given a page graph with a loop", t, func() {
+		page := NewPage("http://local.link/", "Title")
+		page.Status = 200
+		asset, _ := NewAsset("bbbb.js", AssetType_JS)
+		page.AddAsset(asset)
+		rpage, _ := NewAsset("http://remote.link/", AssetType_HTML)
+		page.AddRemotePage(rpage)
+		lpage := NewPage("http://local.link/ffff", "Title2")
+		lpage.Error = "connection refused"
+		page.AddPage(lpage)
+		lpage.AddPage(page)
+
+		Convey("Write it as JSON and read it back", func() {
+			var buf bytes.Buffer
+			So(WriteJSON(&buf, page), ShouldBeNil)
+
+			root, err := ReadJSON(&buf)
+			So(err, ShouldBeNil)
+			So(root.URI, ShouldEqual, "http://local.link/")
+			So(root.Title, ShouldEqual, "Title")
+			So(root.Status, ShouldEqual, 200)
+			So(root.Assets, ShouldResemble, page.Assets)
+			So(root.RemotePages, ShouldResemble, page.RemotePages)
+			So(len(root.Pages), ShouldEqual, 1)
+			So(root.Pages[0].Title, ShouldEqual, "Title2")
+			So(root.Pages[0].Error, ShouldEqual, "connection refused")
+			So(root.Pages[0].Pages, ShouldResemble, []*Page{root})
+		})
+	})
+
+	Convey("Reading a crawl without its seed page fails", t, func() {
+		_, err := ReadJSON(bytes.NewBufferString(`{"seed": "http://local.link/", "pages": []}`))
+		So(err, ShouldNotBeNil)
+	})
+}
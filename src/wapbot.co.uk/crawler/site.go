@@ -0,0 +1,167 @@
+package crawler
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/*
+ * A pool limits how many pages are fetched at once. A nil pool has no
+ * limit.
+ */
+type pool chan struct{}
+
+func (p pool) acquire() {
+	if p != nil {
+		p <- struct{}{}
+	}
+}
+
+func (p pool) release() {
+	if p != nil {
+		<-p
+	}
+}
+
+/**
+ * This struct describes a site to crawl as part of ProcessSites.
+ */
+type Site struct {
+	// The pages to start crawling from.
+	Seeds []*url.URL
+	// Links outside of the scope's host and path are remote. Defaults to
+	// the first seed.
+	Scope *url.URL
+	// The time to leave between starting fetches from the site.
+	Delay time.Duration
+	// The most pages fetched from the site at once. Zero means no limit
+	// other than Options.Workers.
+	Concurrency int
+}
+
+/**
+ * This struct holds the outcome of crawling one site.
+ */
+type SiteResult struct {
+	Site *Site
+	// The page of each seed, in the same order as the seeds. A page is
+	// nil if its seed could not be crawled.
+	Pages []*Page
+	// The first error, if any.
+	Err error
+}
+
+/**
+ * Group seed URLs into sites by scheme and host, in the order the sites
+ * first appear. A site with a single seed is scoped to it; a site with
+ * several seeds is scoped to the whole host.
+ */
+func GroupSeeds(seeds []*url.URL) []*Site {
+	var sites []*Site
+	byHost := make(map[string]*Site)
+	for _, seed := range seeds {
+		key := seed.Scheme + "://" + seed.Host
+		site, exists := byHost[key]
+		if !exists {
+			site = new(Site)
+			byHost[key] = site
+			sites = append(sites, site)
+		}
+		site.Seeds = append(site.Seeds, seed)
+	}
+
+	for _, site := range sites {
+		if len(site.Seeds) > 1 {
+			site.Scope = &url.URL{Scheme: site.Seeds[0].Scheme, Host: site.Seeds[0].Host, Path: "/"}
+		}
+	}
+
+	return sites
+}
+
+/**
+ * Crawl several sites at once. Each site is crawled separately, within
+ * its own scope and at its own pace, but every fetch shares the pool of
+ * Options.Workers. The results are in the same order as the sites.
+ *
+ * Options.Store and Options.Previous can only be used when there is a
+ * single site with a single seed.
+ */
+func ProcessSites(sites []*Site, opts *Options) ([]*SiteResult, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	if (opts.Store != nil || opts.Previous != nil) && (len(sites) != 1 || len(sites[0].Seeds) != 1) {
+		return nil, errors.New("a store or previous crawl can only be used when crawling a single seed")
+	}
+	for _, site := range sites {
+		if len(site.Seeds) == 0 {
+			return nil, errors.New("a site has no seeds")
+		}
+	}
+
+	fetcher := opts.Fetcher
+	if fetcher == nil {
+		fetcher = defaultClient
+	}
+
+	var previous map[string]*PageRecord
+	if opts.Previous != nil {
+		records, err := opts.Previous.Pages()
+		if err != nil {
+			return nil, err
+		}
+
+		previous = make(map[string]*PageRecord)
+		for _, rec := range records {
+			previous[rec.URI] = rec
+		}
+	}
+
+	var workers pool
+	if opts.Workers > 0 {
+		workers = make(pool, opts.Workers)
+	}
+
+	results := make([]*SiteResult, len(sites))
+	var wg sync.WaitGroup
+	for i, site := range sites {
+		scope := site.Scope
+		if scope == nil {
+			scope = site.Seeds[0]
+		}
+
+		c := newCrawl(scope, fetcher, nil)
+		if opts.Context != nil {
+			c.ctx = opts.Context
+		}
+		c.store = opts.Store
+		c.previous = previous
+		c.onPage = opts.OnPage
+		c.hooks = opts.Hooks
+		c.retry = opts.Retry
+		c.avoid = opts.Avoid
+		if opts.Logger != nil {
+			c.log = opts.Logger.With("site", scope.String())
+		}
+		c.pool = workers
+		c.delay = site.Delay
+		if site.Concurrency > 0 {
+			c.slots = make(chan struct{}, site.Concurrency)
+		}
+
+		wg.Add(1)
+		go func(i int, site *Site, c *crawl) {
+			defer wg.Done()
+			result := &SiteResult{Site: site}
+			result.Pages, result.Err = c.run(site.Seeds)
+			results[i] = result
+		}(i, site, c)
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,173 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * A fetcher for two small sites which records how many requests are in
+ * flight at once, overall and for each host, and when each request was
+ * made.
+ */
+type siteFetcher struct {
+	sync.Mutex
+	active  int
+	max     int
+	hosts   map[string]int
+	maxHost map[string]int
+	times   map[string][]time.Time
+}
+
+func newSiteFetcher() *siteFetcher {
+	return &siteFetcher{hosts: make(map[string]int), maxHost: make(map[string]int), times: make(map[string][]time.Time)}
+}
+
+func (sf *siteFetcher) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	sf.Lock()
+	sf.active++
+	sf.hosts[host]++
+	if sf.active > sf.max {
+		sf.max = sf.active
+	}
+	if sf.hosts[host] > sf.maxHost[host] {
+		sf.maxHost[host] = sf.hosts[host]
+	}
+	sf.times[host] = append(sf.times[host], time.Now())
+	sf.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	sf.Lock()
+	sf.active--
+	sf.hosts[host]--
+	sf.Unlock()
+
+	body := `<html><head><title>` + req.URL.String() + `</title></head><body>
+		<a href="/1">1</a><a href="/2">2</a><a href="/3">3</a><a href="/blog/4">4</a>
+		<a href="http://two.link/">Two</a></body></html>`
+	resp := new(http.Response)
+	resp.StatusCode = 200
+	resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+	resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+	return resp, nil
+}
+
+func Test_GroupSeeds(t *testing.T) {
+	Convey("Given seeds on several sites", t, func() {
+		var seeds []*url.URL
+		for _, s := range []string{"http://one.link/blog/", "https://two.link/", "http://one.link/docs/"} {
+			u, _ := url.Parse(s)
+			seeds = append(seeds, u)
+		}
+
+		Convey("Check that the seeds are grouped by host", func() {
+			sites := GroupSeeds(seeds)
+			So(len(sites), ShouldEqual, 2)
+			So(len(sites[0].Seeds), ShouldEqual, 2)
+			So(sites[0].Scope.String(), ShouldEqual, "http://one.link/")
+			So(len(sites[1].Seeds), ShouldEqual, 1)
+			So(sites[1].Scope, ShouldBeNil)
+		})
+	})
+}
+
+func Test_ProcessSites(t *testing.T) {
+	Convey("Given two sites", t, func() {
+		one, _ := url.Parse("http://one.link/")
+		blog, _ := url.Parse("http://one.link/blog/4")
+		two, _ := url.Parse("http://two.link/")
+		fetcher := newSiteFetcher()
+
+		Convey("Check that each site gets its own graph and scope", func() {
+			sites := []*Site{{Seeds: []*url.URL{one, blog}}, {Seeds: []*url.URL{two}}}
+			results, err := ProcessSites(sites, &Options{Fetcher: fetcher})
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 2)
+
+			So(results[0].Err, ShouldBeNil)
+			So(results[0].Site, ShouldEqual, sites[0])
+			So(len(results[0].Pages), ShouldEqual, 2)
+			So(results[0].Pages[0].URI, ShouldEqual, "http://one.link/")
+			So(results[0].Pages[1].URI, ShouldEqual, "http://one.link/blog/4")
+			So(len(results[0].Pages[0].Pages), ShouldEqual, 4)
+			So(len(results[0].Pages[0].RemotePages), ShouldEqual, 1)
+			// Both seeds are in the same graph.
+			So(results[0].Pages[0].Pages, ShouldContain, results[0].Pages[1])
+
+			So(results[1].Pages[0].URI, ShouldEqual, "http://two.link/")
+			So(len(results[1].Pages[0].Pages), ShouldEqual, 4)
+			So(len(results[1].Pages[0].RemotePages), ShouldEqual, 0)
+		})
+
+		Convey("Check that the worker pool is shared by the sites", func() {
+			sites := []*Site{{Seeds: []*url.URL{one}}, {Seeds: []*url.URL{two}}}
+			_, err := ProcessSites(sites, &Options{Fetcher: fetcher, Workers: 2})
+			So(err, ShouldBeNil)
+			So(fetcher.max, ShouldBeLessThanOrEqualTo, 2)
+		})
+
+		Convey("Check that the politeness of each site is respected", func() {
+			delay := 20 * time.Millisecond
+			sites := []*Site{{Seeds: []*url.URL{one}, Concurrency: 1, Delay: delay}, {Seeds: []*url.URL{two}, Concurrency: 2}}
+			_, err := ProcessSites(sites, &Options{Fetcher: fetcher})
+			So(err, ShouldBeNil)
+			So(fetcher.maxHost["one.link"], ShouldEqual, 1)
+			So(fetcher.maxHost["two.link"], ShouldBeLessThanOrEqualTo, 2)
+
+			times := fetcher.times["one.link"]
+			So(len(times), ShouldEqual, 5)
+			for i := 1; i < len(times); i++ {
+				So(times[i].Sub(times[i-1]), ShouldBeGreaterThanOrEqualTo, delay)
+			}
+		})
+
+		Convey("Check that the delay of a site is respected when the workers are busy", func() {
+			delay := 20 * time.Millisecond
+			sites := []*Site{{Seeds: []*url.URL{one}, Delay: delay}, {Seeds: []*url.URL{two}}}
+			_, err := ProcessSites(sites, &Options{Fetcher: fetcher, Workers: 1})
+			So(err, ShouldBeNil)
+			So(fetcher.max, ShouldEqual, 1)
+
+			times := fetcher.times["one.link"]
+			So(len(times), ShouldEqual, 5)
+			for i := 1; i < len(times); i++ {
+				So(times[i].Sub(times[i-1]), ShouldBeGreaterThanOrEqualTo, delay)
+			}
+		})
+
+		Convey("Check that the crawl stops when its context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Cancel as soon as the seed has been processed, before the
+			// pages it links to are queued.
+			sites := []*Site{{Seeds: []*url.URL{one}}}
+			results, err := ProcessSites(sites, &Options{Fetcher: fetcher, Context: ctx, OnPage: func(*Page) {
+				cancel()
+			}})
+			So(err, ShouldBeNil)
+			So(results[0].Err, ShouldEqual, context.Canceled)
+			So(results[0].Pages[0].URI, ShouldEqual, "http://one.link/")
+			So(len(results[0].Pages[0].Pages), ShouldEqual, 0)
+			So(len(fetcher.times["one.link"]), ShouldEqual, 1)
+		})
+
+		Convey("Check that a store can only be used with a single seed", func() {
+			store, err := OpenStore(t.TempDir())
+			So(err, ShouldBeNil)
+			defer store.Close()
+
+			sites := []*Site{{Seeds: []*url.URL{one}}, {Seeds: []*url.URL{two}}}
+			_, err = ProcessSites(sites, &Options{Fetcher: fetcher, Store: store})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,116 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+ * The parts of a sitemap or sitemap index that are used.
+ */
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+/**
+ * Read a sitemap, which may be gzipped, and return the page URLs it lists.
+ * If it is a sitemap index the URLs of the sitemaps it lists are returned
+ * instead.
+ */
+func ReadSitemap(r io.Reader) ([]string, []string, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	sitemap := new(sitemapXML)
+	if err := xml.NewDecoder(r).Decode(sitemap); err != nil {
+		return nil, nil, err
+	}
+	if sitemap.XMLName.Local != "urlset" && sitemap.XMLName.Local != "sitemapindex" {
+		return nil, nil, errors.New("not a sitemap: " + sitemap.XMLName.Local)
+	}
+
+	trim := func(uris []string) []string {
+		for i := range uris {
+			uris[i] = strings.TrimSpace(uris[i])
+		}
+		return uris
+	}
+
+	return trim(sitemap.URLs), trim(sitemap.Sitemaps), nil
+}
+
+/**
+ * Fetch a sitemap and return the page URLs it lists, following sitemap
+ * indexes to the sitemaps they list.
+ */
+func FetchSitemap(fetcher Fetcher, uri string) ([]string, error) {
+	var urls []string
+	fetched := make(map[string]bool)
+	queue := []string{uri}
+	for len(queue) > 0 {
+		uri := queue[0]
+		queue = queue[1:]
+		if fetched[uri] {
+			continue
+		}
+		fetched[uri] = true
+
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := fetcher.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: HTTP %d", uri, resp.StatusCode)
+		}
+
+		pages, sitemaps, err := ReadSitemap(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.New(uri + ": " + err.Error())
+		}
+		urls = append(urls, pages...)
+		queue = append(queue, sitemaps...)
+	}
+
+	return urls, nil
+}
+
+/**
+ * Read a list of URLs, one per line. Blank lines and lines starting with
+ * # are ignored.
+ */
+func ReadURLList(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+
+	return urls, scanner.Err()
+}
@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_ReadSitemap(t *testing.T) {
+	Convey("Given a sitemap", t, func() {
+		sitemap := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>http://local.link/</loc></url>
+	<url>
+		<loc>
+			http://local.link/a
+		</loc>
+		<lastmod>2020-01-01</lastmod>
+	</url>
+</urlset>`
+
+		Convey("Check that the page URLs are read", func() {
+			urls, sitemaps, err := ReadSitemap(strings.NewReader(sitemap))
+			So(err, ShouldBeNil)
+			So(urls, ShouldResemble, []string{"http://local.link/", "http://local.link/a"})
+			So(len(sitemaps), ShouldEqual, 0)
+		})
+
+		Convey("Check that a gzipped sitemap is read", func() {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(sitemap))
+			gz.Close()
+
+			urls, _, err := ReadSitemap(&buf)
+			So(err, ShouldBeNil)
+			So(len(urls), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Check that something other than a sitemap is rejected", t, func() {
+		_, _, err := ReadSitemap(strings.NewReader("<html></html>"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_FetchSitemap(t *testing.T) {
+	Convey("Given a sitemap index", t, func() {
+		fetched := 0
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			fetched++
+			body := `<sitemapindex><sitemap><loc>http://local.link/one.xml</loc></sitemap>
+				<sitemap><loc>http://local.link/two.xml</loc></sitemap>
+				<sitemap><loc>http://local.link/one.xml</loc></sitemap></sitemapindex>`
+			switch req.URL.Path {
+			case "/one.xml":
+				body = `<urlset><url><loc>http://local.link/a</loc></url></urlset>`
+			case "/two.xml":
+				body = `<urlset><url><loc>http://local.link/b</loc></url></urlset>`
+			}
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		Convey("Check that the listed sitemaps are followed once each", func() {
+			urls, err := FetchSitemap(site, "http://local.link/sitemap.xml")
+			So(err, ShouldBeNil)
+			So(urls, ShouldResemble, []string{"http://local.link/a", "http://local.link/b"})
+			So(fetched, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Check that a missing sitemap is an error", t, func() {
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			resp := new(http.Response)
+			resp.StatusCode = 404
+			resp.Body = &openCloseBuffer{bytes.NewBufferString("")}
+			return resp, nil
+		})
+		_, err := FetchSitemap(site, "http://local.link/sitemap.xml")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_ReadURLList(t *testing.T) {
+	Convey("Check that blank lines and comments are skipped", t, func() {
+		urls, err := ReadURLList(strings.NewReader("# Pages\nhttp://local.link/\n\n  http://local.link/a  \n"))
+		So(err, ShouldBeNil)
+		So(urls, ShouldResemble, []string{"http://local.link/", "http://local.link/a"})
+	})
+}
@@ -0,0 +1,306 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	bucketMeta     = []byte("meta")
+	bucketPages    = []byte("pages")
+	bucketFrontier = []byte("frontier")
+	bucketSkipped  = []byte("skipped")
+
+	keySeed = []byte("seed")
+)
+
+/**
+ * This struct is the flattened form of a Page that is written to disk.
+ * Links to other local pages are held by URI rather than by pointer.
+ */
+type PageRecord struct {
+	URI          string     `json:"uri"`
+	Title        string     `json:"title"`
+	Status       int        `json:"status,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Retries      int        `json:"retries,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"last_modified,omitempty"`
+	Digest       string     `json:"digest,omitempty"`
+	TextDigest   string     `json:"text_digest,omitempty"`
+	SimHash      uint64     `json:"simhash,omitempty"`
+	Change       ChangeType `json:"change,omitempty"`
+	Assets       []*Asset   `json:"assets,omitempty"`
+	RemotePages  []*Asset   `json:"remote_pages,omitempty"`
+	Pages        []string   `json:"pages,omitempty"`
+	Links        []*Link    `json:"links,omitempty"`
+	Depth        int        `json:"depth,omitempty"`
+	InDegree     int        `json:"in_degree,omitempty"`
+	OutDegree    int        `json:"out_degree,omitempty"`
+	PageRank     float64    `json:"pagerank,omitempty"`
+}
+
+/**
+ * Create a record from a page and the URIs of the local pages it links to.
+ */
+func NewPageRecord(p *Page, links []string) *PageRecord {
+	p.Lock()
+	defer p.Unlock()
+
+	rec := new(PageRecord)
+	rec.URI = p.URI
+	rec.Title = p.Title
+	rec.Status = p.Status
+	rec.Error = p.Error
+	rec.Retries = p.Retries
+	rec.ETag = p.ETag
+	rec.LastModified = p.LastModified
+	rec.Digest = p.Digest
+	rec.TextDigest = p.TextDigest
+	rec.SimHash = p.SimHash
+	rec.Change = p.Change
+	rec.Assets = append(rec.Assets, p.Assets...)
+	rec.RemotePages = append(rec.RemotePages, p.RemotePages...)
+	rec.Pages = append(rec.Pages, links...)
+	rec.Links = append(rec.Links, p.Links...)
+	rec.Depth = p.Depth
+	rec.InDegree = p.InDegree
+	rec.OutDegree = p.OutDegree
+	rec.PageRank = p.PageRank
+
+	return rec
+}
+
+/**
+ * Create a page from a record. The local pages are not linked; that is
+ * left to the caller once all records are loaded.
+ */
+func (r *PageRecord) Page() *Page {
+	page := NewPage(r.URI, r.Title)
+	page.Status = r.Status
+	page.Error = r.Error
+	page.Retries = r.Retries
+	page.ETag = r.ETag
+	page.LastModified = r.LastModified
+	page.Digest = r.Digest
+	page.TextDigest = r.TextDigest
+	page.SimHash = r.SimHash
+	page.Change = r.Change
+	page.Assets = append(page.Assets, r.Assets...)
+	page.RemotePages = append(page.RemotePages, r.RemotePages...)
+	page.Links = append(page.Links, r.Links...)
+	page.Depth = r.Depth
+	page.InDegree = r.InDegree
+	page.OutDegree = r.OutDegree
+	page.PageRank = r.PageRank
+
+	return page
+}
+
+/**
+ * A Store checkpoints the state of a crawl to an on-disk database so
+ * that an interrupted crawl can be resumed. It records the seed URI,
+ * every completed page, the frontier of URIs that have been discovered
+ * but not yet completed, and the URIs that could not be crawled.
+ */
+type Store struct {
+	db *bolt.DB
+}
+
+/**
+ * Open (creating if necessary) the crawl store kept in the directory dir.
+ */
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "crawl.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketPages, bucketFrontier, bucketSkipped} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+/**
+ * Open the crawl store kept in the directory dir for reading only. Unlike
+ * OpenStore it fails, leaving dir untouched, if dir holds no store.
+ */
+func OpenStoreReadOnly(dir string) (*Store, error) {
+	path := filepath.Join(dir, "crawl.db")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, errors.New(dir + " holds no saved crawl")
+	} else if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+/**
+ * Close the store.
+ */
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+/**
+ * Return the seed URI of the crawl held in the store, or an empty string
+ * if no crawl has been started.
+ */
+func (s *Store) Seed() (string, error) {
+	var seed string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		seed = string(tx.Bucket(bucketMeta).Get(keySeed))
+		return nil
+	})
+
+	return seed, err
+}
+
+/**
+ * Record the seed URI of the crawl. A store only ever holds one crawl, so
+ * this fails if a different seed has already been recorded.
+ */
+func (s *Store) SetSeed(seed string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketMeta)
+		if old := b.Get(keySeed); old != nil && string(old) != seed {
+			return errors.New("store already holds a crawl of " + string(old))
+		}
+		return b.Put(keySeed, []byte(seed))
+	})
+}
+
+/**
+ * Record a completed page and add the newly discovered URIs to the
+ * frontier. Both happen in a single transaction so a crash can never
+ * lose the links of a completed page.
+ */
+func (s *Store) SavePage(rec *PageRecord, discovered []string) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketPages).Put([]byte(rec.URI), data); err != nil {
+			return err
+		}
+
+		frontier := tx.Bucket(bucketFrontier)
+		if err := frontier.Delete([]byte(rec.URI)); err != nil {
+			return err
+		}
+		for _, uri := range discovered {
+			if err := frontier.Put([]byte(uri), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * Add URIs to the frontier.
+ */
+func (s *Store) AddFrontier(uris ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		frontier := tx.Bucket(bucketFrontier)
+		for _, uri := range uris {
+			if err := frontier.Put([]byte(uri), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/**
+ * Move a URI from the frontier to the skipped list, recording why it
+ * could not be crawled.
+ */
+func (s *Store) SkipPage(uri string, reason string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketFrontier).Delete([]byte(uri)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketSkipped).Put([]byte(uri), []byte(reason))
+	})
+}
+
+/**
+ * Return every completed page held in the store.
+ */
+func (s *Store) Pages() ([]*PageRecord, error) {
+	var records []*PageRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPages).ForEach(func(_, v []byte) error {
+			rec := new(PageRecord)
+			if err := json.Unmarshal(v, rec); err != nil {
+				return err
+			}
+			records = append(records, rec)
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+/**
+ * Return the URIs which have been discovered but not yet completed.
+ */
+func (s *Store) Frontier() ([]string, error) {
+	return s.keys(bucketFrontier)
+}
+
+/**
+ * Return the URIs which could not be crawled, mapped to the reason why.
+ */
+func (s *Store) Skipped() (map[string]string, error) {
+	skipped := make(map[string]string)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSkipped).ForEach(func(k, v []byte) error {
+			skipped[string(k)] = string(v)
+			return nil
+		})
+	})
+
+	return skipped, err
+}
+
+func (s *Store) keys(bucket []byte) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+
+	return keys, err
+}
@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+)
+
+func Test_Store(t *testing.T) {
+	Convey("Given a new store", t, func() {
+		dir := t.TempDir()
+		store, err := OpenStore(dir)
+		So(err, ShouldBeNil)
+		So(store, ShouldNotBeNil)
+
+		Convey("It holds no crawl", func() {
+			seed, err := store.Seed()
+			So(err, ShouldBeNil)
+			So(seed, ShouldEqual, "")
+			store.Close()
+		})
+
+		Convey("It can be opened read only once closed", func() {
+			So(store.SetSeed("http://local.link/"), ShouldBeNil)
+			So(store.Close(), ShouldBeNil)
+
+			store, err = OpenStoreReadOnly(dir)
+			So(err, ShouldBeNil)
+			defer store.Close()
+			seed, err := store.Seed()
+			So(err, ShouldBeNil)
+			So(seed, ShouldEqual, "http://local.link/")
+			So(store.AddFrontier("http://local.link/a"), ShouldNotBeNil)
+		})
+
+		Convey("Only one seed can be recorded", func() {
+			So(store.SetSeed("http://local.link/"), ShouldBeNil)
+			So(store.SetSeed("http://local.link/"), ShouldBeNil)
+			So(store.SetSeed("http://other.link/"), ShouldNotBeNil)
+			store.Close()
+		})
+
+		Convey("Save some crawl state and reopen the store", func() {
+			So(store.SetSeed("http://local.link/"), ShouldBeNil)
+			So(store.AddFrontier("http://local.link/"), ShouldBeNil)
+
+			page := NewPage("http://local.link/", "Title")
+			page.Change = ChangeType_Changed
+			asset, _ := NewAsset("image.jpg", AssetType_IMG)
+			page.AddAsset(asset)
+			rec := NewPageRecord(page, []string{"http://local.link/a", "http://local.link/b"})
+			So(store.SavePage(rec, []string{"http://local.link/a", "http://local.link/b"}), ShouldBeNil)
+			So(store.SkipPage("http://local.link/b", "not html"), ShouldBeNil)
+			So(store.Close(), ShouldBeNil)
+
+			store, err = OpenStore(dir)
+			So(err, ShouldBeNil)
+			defer store.Close()
+
+			seed, err := store.Seed()
+			So(err, ShouldBeNil)
+			So(seed, ShouldEqual, "http://local.link/")
+
+			records, err := store.Pages()
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 1)
+			So(records[0].URI, ShouldEqual, "http://local.link/")
+			So(records[0].Title, ShouldEqual, "Title")
+			So(records[0].Change, ShouldEqual, ChangeType_Changed)
+			So(records[0].Page().Change, ShouldEqual, ChangeType_Changed)
+			So(len(records[0].Assets), ShouldEqual, 1)
+			So(records[0].Assets[0].URI, ShouldEqual, "image.jpg")
+			So(records[0].Pages, ShouldResemble, []string{"http://local.link/a", "http://local.link/b"})
+
+			frontier, err := store.Frontier()
+			So(err, ShouldBeNil)
+			So(frontier, ShouldResemble, []string{"http://local.link/a"})
+
+			skipped, err := store.Skipped()
+			So(err, ShouldBeNil)
+			So(skipped["http://local.link/b"], ShouldEqual, "not html")
+		})
+	})
+}
+
+func Test_OpenStoreReadOnly(t *testing.T) {
+	Convey("Check that a directory without a store is not written to", t, func() {
+		dir := t.TempDir()
+		_, err := OpenStoreReadOnly(dir)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "holds no saved crawl")
+
+		entries, err := os.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(entries, ShouldBeEmpty)
+	})
+}
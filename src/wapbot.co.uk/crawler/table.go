@@ -0,0 +1,203 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/**
+ * A TableWriter writes a crawl as three tables for spreadsheets: the
+ * pages, the links between them and the assets they use. The link and
+ * asset rows of a page can be written as soon as the page has been
+ * processed, by passing WritePage as Options.OnPage. The pages table
+ * holds the depth, link counts and PageRank of each page so it can only
+ * be written once the crawl is complete and analysed, by WritePages.
+ */
+type TableWriter struct {
+	sync.Mutex
+	pages  *csv.Writer
+	links  *csv.Writer
+	assets *csv.Writer
+	// The files written to, if the writer created them.
+	files []*os.File
+	// The first error, if any.
+	err error
+}
+
+/**
+ * Create a writer for the three tables, separating fields with comma.
+ * The header rows of the links and assets tables are written at once.
+ */
+func NewTableWriter(pages io.Writer, links io.Writer, assets io.Writer, comma rune) *TableWriter {
+	tw := new(TableWriter)
+	tw.pages = csv.NewWriter(pages)
+	tw.links = csv.NewWriter(links)
+	tw.assets = csv.NewWriter(assets)
+	for _, w := range []*csv.Writer{tw.pages, tw.links, tw.assets} {
+		w.Comma = comma
+	}
+
+	tw.write(tw.links, "source", "target", "anchor", "rel", "title", "location", "scope")
+	tw.write(tw.assets, "page", "asset", "type")
+
+	return tw
+}
+
+/**
+ * Create pages, links and assets files in dir and a writer for them. The
+ * files have a .tsv extension if comma is a tab and .csv otherwise.
+ */
+func CreateTables(dir string, comma rune) (*TableWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ext := ".csv"
+	if comma == '\t' {
+		ext = ".tsv"
+	}
+
+	var files []*os.File
+	for _, name := range []string{"pages", "links", "assets"} {
+		f, err := os.Create(filepath.Join(dir, name+ext))
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	tw := NewTableWriter(files[0], files[1], files[2], comma)
+	tw.files = files
+
+	return tw, nil
+}
+
+/*
+ * Write a row, keeping the first error. The caller must hold the lock, or
+ * be the only user of the writer.
+ */
+func (tw *TableWriter) write(w *csv.Writer, fields ...string) {
+	if tw.err == nil {
+		tw.err = w.Write(fields)
+	}
+}
+
+/**
+ * Write the link and asset rows of a page.
+ */
+func (tw *TableWriter) WritePage(p *Page) {
+	p.Lock()
+	links := append([]*Link(nil), p.Links...)
+	assets := append([]*Asset(nil), p.Assets...)
+	p.Unlock()
+
+	tw.Lock()
+	defer tw.Unlock()
+
+	for _, l := range links {
+		scope := "internal"
+		if l.Remote {
+			scope = "remote"
+		}
+		tw.write(tw.links, p.URI, l.URI, l.Text, l.Rel, l.Title, l.Location, scope)
+	}
+	for _, a := range assets {
+		tw.write(tw.assets, p.URI, resolveURI(p.URI, a.URI), getTypeString(a.Type))
+	}
+	tw.links.Flush()
+	tw.assets.Flush()
+}
+
+/**
+ * Write the pages table for the pages reachable from the roots, in URI
+ * order. Where a page is reachable from several roots its depth is the
+ * shortest from any of them.
+ */
+func (tw *TableWriter) WritePages(roots ...*Page) {
+	var pages []*Page
+	inbound := make(map[*Page]int)
+	depths := make(map[*Page]int)
+	for _, root := range roots {
+		for p, depth := range root.Depths() {
+			if d, seen := depths[p]; !seen {
+				pages = append(pages, p)
+				for _, np := range p.Pages {
+					inbound[np]++
+				}
+				depths[p] = depth
+			} else if depth < d {
+				depths[p] = depth
+			}
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URI < pages[j].URI
+	})
+
+	tw.Lock()
+	defer tw.Unlock()
+
+	tw.write(tw.pages, "uri", "title", "status", "depth", "inbound", "outbound", "pagerank")
+	for _, p := range pages {
+		tw.write(tw.pages, p.URI, p.Title, strconv.Itoa(p.Status),
+			strconv.Itoa(depths[p]), strconv.Itoa(inbound[p]), strconv.Itoa(len(p.Pages)),
+			strconv.FormatFloat(p.PageRank, 'f', 6, 64))
+	}
+	tw.pages.Flush()
+}
+
+/*
+ * Write one of the pages, links or assets tables of a crawl as CSV. The
+ * pages table is written if table is empty.
+ */
+func writeTable(w io.Writer, root *Page, table string) error {
+	pages, links, assets := io.Discard, io.Discard, io.Discard
+	switch table {
+	case "", "pages":
+		pages = w
+	case "links":
+		links = w
+	case "assets":
+		assets = w
+	default:
+		return errBadTable
+	}
+
+	tw := NewTableWriter(pages, links, assets, ',')
+	root.Walk(tw.WritePage)
+	tw.WritePages(root)
+
+	return tw.Close()
+}
+
+/**
+ * Flush the tables, close any files the writer created and return the
+ * first error that occurred while writing.
+ */
+func (tw *TableWriter) Close() error {
+	tw.Lock()
+	defer tw.Unlock()
+
+	for _, w := range []*csv.Writer{tw.pages, tw.links, tw.assets} {
+		w.Flush()
+		if tw.err == nil {
+			tw.err = w.Error()
+		}
+	}
+	for _, f := range tw.files {
+		if err := f.Close(); err != nil && tw.err == nil {
+			tw.err = err
+		}
+	}
+	tw.files = nil
+
+	return tw.err
+}
@@ -0,0 +1,118 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/csv"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_TableWriter(t *testing.T) {
+	Convey("Given a crawl of a small site", t, func() {
+		site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+			body := `<html><head><title>Home</title><link rel="stylesheet" href="/style.css"></head>
+				<body><nav><a href="/a" title="Go to A">Page, "A"</a></nav><a href="http://remote.link/" rel="nofollow">Remote</a></body></html>`
+			if req.URL.Path == "/a" {
+				body = `<html><head><title>A</title></head><body><img src="logo.png"><a href="/">Home</a></body></html>`
+			}
+			resp := new(http.Response)
+			resp.StatusCode = 200
+			resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+			resp.Body = &openCloseBuffer{bytes.NewBufferString(body)}
+			return resp, nil
+		})
+
+		var pages, links, assets bytes.Buffer
+		tw := NewTableWriter(&pages, &links, &assets, ',')
+
+		u, _ := url.Parse("http://local.link/")
+		root, err := ProcessPageWithOptions(u, &Options{Fetcher: site, OnPage: tw.WritePage})
+		So(err, ShouldBeNil)
+
+		Convey("Check that links and assets were streamed during the crawl", func() {
+			rows, err := csv.NewReader(&links).ReadAll()
+			So(err, ShouldBeNil)
+			So(len(rows), ShouldEqual, 4)
+			So(rows[0], ShouldResemble, []string{"source", "target", "anchor", "rel", "title", "location", "scope"})
+			So(rows, ShouldContain, []string{"http://local.link/", "http://local.link/a", `Page, "A"`, "", "Go to A", "nav", "internal"})
+			So(rows, ShouldContain, []string{"http://local.link/", "http://remote.link/", "Remote", "nofollow", "", "", "remote"})
+			So(rows, ShouldContain, []string{"http://local.link/a", "http://local.link/", "Home", "", "", "", "internal"})
+
+			rows, err = csv.NewReader(&assets).ReadAll()
+			So(err, ShouldBeNil)
+			So(len(rows), ShouldEqual, 3)
+			So(rows[0], ShouldResemble, []string{"page", "asset", "type"})
+			So(rows, ShouldContain, []string{"http://local.link/", "http://local.link/style.css", "CSS"})
+			So(rows, ShouldContain, []string{"http://local.link/a", "http://local.link/logo.png", "Image"})
+			So(pages.Len(), ShouldEqual, 0)
+		})
+
+		Convey("Check that the pages table is written at the end", func() {
+			Analyse(root, DefaultDamping, DefaultIterations)
+			tw.WritePages(root)
+			So(tw.Close(), ShouldBeNil)
+
+			rows, err := csv.NewReader(&pages).ReadAll()
+			So(err, ShouldBeNil)
+			So(rows, ShouldResemble, [][]string{
+				{"uri", "title", "status", "depth", "inbound", "outbound", "pagerank"},
+				{"http://local.link/", "Home", "200", "0", "1", "1", "0.500000"},
+				{"http://local.link/a", "A", "200", "1", "1", "1", "0.500000"},
+			})
+		})
+	})
+
+	Convey("Given a directory to write tab separated tables to", t, func() {
+		dir := t.TempDir()
+		tw, err := CreateTables(dir, '\t')
+		So(err, ShouldBeNil)
+
+		Convey("Check that tsv files are created", func() {
+			root := NewPage("http://local.link/", "Home\tpage")
+			tw.WritePage(root)
+			tw.WritePages(root)
+			So(tw.Close(), ShouldBeNil)
+
+			data, err := os.ReadFile(filepath.Join(dir, "pages.tsv"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "uri\ttitle\tstatus\tdepth\tinbound\toutbound\tpagerank\nhttp://local.link/\t\"Home\tpage\"\t0\t0\t0\t0\t0.000000\n")
+			_, err = os.Stat(filepath.Join(dir, "links.tsv"))
+			So(err, ShouldBeNil)
+			_, err = os.Stat(filepath.Join(dir, "assets.tsv"))
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func Test_TableWriter_Roots(t *testing.T) {
+	Convey("Given two crawls sharing a page", t, func() {
+		one := NewPage("http://one.link/", "One")
+		two := NewPage("http://two.link/", "Two")
+		deep := NewPage("http://two.link/deep", "Deep")
+		shared := NewPage("http://two.link/shared", "Shared")
+		one.AddPage(shared)
+		two.AddPage(deep)
+		deep.AddPage(shared)
+
+		Convey("Check that each page is written once with its shortest depth", func() {
+			var pages, links, assets bytes.Buffer
+			tw := NewTableWriter(&pages, &links, &assets, ',')
+			tw.WritePages(one, two)
+			So(tw.Close(), ShouldBeNil)
+
+			rows, err := csv.NewReader(&pages).ReadAll()
+			So(err, ShouldBeNil)
+			So(rows, ShouldResemble, [][]string{
+				{"uri", "title", "status", "depth", "inbound", "outbound", "pagerank"},
+				{"http://one.link/", "One", "0", "0", "0", "1", "0.000000"},
+				{"http://two.link/", "Two", "0", "0", "0", "1", "0.000000"},
+				{"http://two.link/deep", "Deep", "0", "1", "1", "1", "0.000000"},
+				{"http://two.link/shared", "Shared", "0", "1", "2", "0", "0.000000"},
+			})
+		})
+	})
+}
@@ -0,0 +1,392 @@
+package crawler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * This struct is a single WARC 1.1 record. The named fields are kept in
+ * the order they were added; Content-Length is added when the record is
+ * written.
+ */
+type WARCRecord struct {
+	Fields [][2]string
+	Block  []byte
+}
+
+/*
+ * Return a new record ID.
+ */
+func newRecordID() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+/*
+ * Return the WARC digest of some data.
+ */
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+/**
+ * Create a record with a new ID and a digest of the block. The target URI
+ * may be empty.
+ */
+func NewWARCRecord(typ string, targetURI string, date time.Time, contentType string, block []byte) *WARCRecord {
+	r := new(WARCRecord)
+	r.Set("WARC-Type", typ)
+	r.Set("WARC-Record-ID", newRecordID())
+	r.Set("WARC-Date", date.UTC().Format(time.RFC3339))
+	if targetURI != "" {
+		r.Set("WARC-Target-URI", targetURI)
+	}
+	r.Set("Content-Type", contentType)
+	r.Set("WARC-Block-Digest", warcDigest(block))
+	r.Block = block
+
+	return r
+}
+
+/**
+ * Return the value of a named field, or an empty string.
+ */
+func (r *WARCRecord) Get(name string) string {
+	for _, f := range r.Fields {
+		if strings.EqualFold(f[0], name) {
+			return f[1]
+		}
+	}
+
+	return ""
+}
+
+/**
+ * Set a named field, replacing any existing value.
+ */
+func (r *WARCRecord) Set(name string, value string) {
+	for i, f := range r.Fields {
+		if strings.EqualFold(f[0], name) {
+			r.Fields[i][1] = value
+			return
+		}
+	}
+
+	r.Fields = append(r.Fields, [2]string{name, value})
+}
+
+/**
+ * Write the record in WARC format.
+ */
+func (r *WARCRecord) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString("WARC/1.1\r\n")
+	for _, f := range r.Fields {
+		fmt.Fprintf(&buf, "%s: %s\r\n", f[0], f[1])
+	}
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(r.Block))
+	buf.Write(r.Block)
+	buf.WriteString("\r\n\r\n")
+
+	return buf.WriteTo(w)
+}
+
+/**
+ * A WARCWriter writes records to a series of WARC files, starting a new
+ * file once the current one reaches MaxSize. If path is "out.warc" the
+ * files are named "out-00000.warc", "out-00001.warc" and so on. A path
+ * ending in ".gz" gzips each record individually.
+ */
+type WARCWriter struct {
+	// The size a file may grow to before a new one is started. Zero
+	// means never start a new file.
+	MaxSize int64
+
+	sync.Mutex
+	prefix   string
+	ext      string
+	compress bool
+	seq      int
+	f        *os.File
+	size     int64
+}
+
+/**
+ * Create a WARC writer. No file is created until the first record is
+ * written.
+ */
+func NewWARCWriter(path string, maxSize int64) *WARCWriter {
+	w := new(WARCWriter)
+	w.MaxSize = maxSize
+	w.compress = strings.HasSuffix(path, ".gz")
+
+	w.prefix = strings.TrimSuffix(path, ".gz")
+	w.ext = filepath.Ext(w.prefix)
+	if w.ext == "" {
+		w.ext = ".warc"
+	}
+	w.prefix = strings.TrimSuffix(w.prefix, w.ext)
+	if w.compress {
+		w.ext += ".gz"
+	}
+
+	return w
+}
+
+/*
+ * Close the current file and open the next one, starting it with a
+ * warcinfo record. The caller must hold the lock.
+ */
+func (w *WARCWriter) rotate() error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := fmt.Sprintf("%s-%05d%s", w.prefix, w.seq, w.ext)
+	w.seq++
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w.f = f
+	w.size = 0
+
+	info := "software: crawlapp\r\nformat: WARC File Format 1.1\r\n"
+	rec := NewWARCRecord("warcinfo", "", time.Now(), "application/warc-fields", []byte(info))
+	rec.Set("WARC-Filename", filepath.Base(name))
+
+	return w.write(rec)
+}
+
+/*
+ * Write one record to the current file. The caller must hold the lock.
+ */
+func (w *WARCWriter) write(r *WARCRecord) error {
+	var buf bytes.Buffer
+	if w.compress {
+		gz := gzip.NewWriter(&buf)
+		if _, err := r.WriteTo(gz); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	} else {
+		r.WriteTo(&buf)
+	}
+
+	n, err := w.f.Write(buf.Bytes())
+	w.size += int64(n)
+
+	return err
+}
+
+/**
+ * Write records. The records are always written to the same file.
+ */
+func (w *WARCWriter) WriteRecords(records ...*WARCRecord) error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.f == nil || (w.MaxSize > 0 && w.size >= w.MaxSize) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range records {
+		if err := w.write(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Close the current file.
+ */
+func (w *WARCWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.f.Close()
+	w.f = nil
+
+	return err
+}
+
+/**
+ * A WARCFetcher records a request and a response record for every
+ * response fetched through it. When the fetcher is a client made by
+ * NewClient the request is recorded as the client sent it, with the
+ * headers and credentials it adds.
+ */
+type WARCFetcher struct {
+	Fetcher Fetcher
+	Writer  *WARCWriter
+}
+
+func (wf *WARCFetcher) Do(req *http.Request) (*http.Response, error) {
+	date := time.Now()
+	sent := new(sentRequest)
+	resp, err := wf.Fetcher.Do(req.WithContext(context.WithValue(req.Context(), sentRequestKey{}, sent)))
+	if err != nil {
+		return nil, err
+	}
+	// After a redirect this is the request for the final response.
+	sentReq := req
+	if sent.req != nil {
+		sentReq = sent.req
+	}
+
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	respBlock, err := dumpResponse(resp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqBlock bytes.Buffer
+	if err := sentReq.Write(&reqBlock); err != nil {
+		return nil, err
+	}
+
+	uri := req.URL.String()
+	response := NewWARCRecord("response", uri, date, "application/http;msgtype=response", respBlock)
+	response.Set("WARC-Payload-Digest", warcDigest(body))
+	request := NewWARCRecord("request", uri, date, "application/http;msgtype=request", reqBlock.Bytes())
+	request.Set("WARC-Concurrent-To", response.Get("WARC-Record-ID"))
+
+	if err := wf.Writer.WriteRecords(response, request); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+/**
+ * A WARCReader reads the records of a WARC file, which may be gzipped.
+ */
+type WARCReader struct {
+	r *bufio.Reader
+}
+
+/**
+ * Create a reader. Gzipped input is detected automatically.
+ */
+func NewWARCReader(r io.Reader) (*WARCReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+
+	return &WARCReader{r: br}, nil
+}
+
+/**
+ * Return the next record, or io.EOF when there are no more.
+ */
+func (wr *WARCReader) Next() (*WARCRecord, error) {
+	// Skip the blank lines between records.
+	var line string
+	for line == "" {
+		l, err := wr.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(l) == "" {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(l, "\r\n")
+	}
+
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, errors.New("invalid WARC record: " + line)
+	}
+
+	r := new(WARCRecord)
+	length := int64(-1)
+	for {
+		l, err := wr.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l = strings.TrimRight(l, "\r\n")
+		if l == "" {
+			break
+		}
+
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("invalid WARC field: " + l)
+		}
+		name, value := parts[0], strings.TrimSpace(parts[1])
+		if strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || length < 0 {
+				return nil, errors.New("invalid WARC Content-Length: " + value)
+			}
+			continue
+		}
+		r.Fields = append(r.Fields, [2]string{name, value})
+	}
+
+	if length < 0 {
+		return nil, errors.New("WARC record has no Content-Length")
+	}
+
+	// The length comes from the file, so read into a buffer which grows
+	// with what is really there rather than trusting it up front.
+	size := length
+	if size > 64<<10 {
+		size = 64 << 10
+	}
+	block := bytes.NewBuffer(make([]byte, 0, size))
+	n, err := block.ReadFrom(io.LimitReader(wr.r, length))
+	if err != nil {
+		return nil, err
+	}
+	if n < length {
+		return nil, fmt.Errorf("WARC record is truncated: Content-Length is %d but only %d bytes follow", length, n)
+	}
+	r.Block = block.Bytes()
+
+	return r, nil
+}
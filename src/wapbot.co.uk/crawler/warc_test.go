@@ -0,0 +1,165 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_WARCRecord(t *testing.T) {
+	Convey("Given a WARC record", t, func() {
+		date := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		rec := NewWARCRecord("resource", "http://local.link/", date, "text/plain", []byte("hello"))
+
+		Convey("Check that it is written correctly", func() {
+			var buf bytes.Buffer
+			_, err := rec.WriteTo(&buf)
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldEqual, "WARC/1.1\r\n"+
+				"WARC-Type: resource\r\n"+
+				"WARC-Record-ID: "+rec.Get("WARC-Record-ID")+"\r\n"+
+				"WARC-Date: 2006-01-02T15:04:05Z\r\n"+
+				"WARC-Target-URI: http://local.link/\r\n"+
+				"Content-Type: text/plain\r\n"+
+				"WARC-Block-Digest: sha1:VL2MMHO4YXUKFWV63YHTWSBM3GXKSQ2N\r\n"+
+				"Content-Length: 5\r\n"+
+				"\r\n"+
+				"hello\r\n\r\n")
+			So(rec.Get("WARC-Record-ID"), ShouldStartWith, "<urn:uuid:")
+
+			Convey("And check that it can be read back", func() {
+				buf.WriteString("\r\n")
+				rec.WriteTo(&buf)
+
+				wr, err := NewWARCReader(&buf)
+				So(err, ShouldBeNil)
+				for i := 0; i < 2; i++ {
+					read, err := wr.Next()
+					So(err, ShouldBeNil)
+					So(read, ShouldResemble, rec)
+				}
+				_, err = wr.Next()
+				So(err, ShouldEqual, io.EOF)
+			})
+		})
+
+		Convey("Check that a record with a bad Content-Length is an error", func() {
+			for _, length := range []string{"999999999999999", "-1", "lots"} {
+				data := "WARC/1.1\r\nWARC-Type: resource\r\nContent-Length: " + length + "\r\n\r\nhello\r\n\r\n"
+				wr, err := NewWARCReader(bytes.NewBufferString(data))
+				So(err, ShouldBeNil)
+				_, err = wr.Next()
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func Test_WARCFetcher(t *testing.T) {
+	site := fetcherFunc(func(req *http.Request) (*http.Response, error) {
+		resp := new(http.Response)
+		resp.StatusCode = 200
+		resp.Header = http.Header{"Content-Type": []string{"text/html"}}
+		resp.Body = &openCloseBuffer{bytes.NewBufferString("<html><head><title>Home</title></head></html>")}
+		return resp, nil
+	})
+
+	Convey("Given a WARC fetcher writing uncompressed records", t, func() {
+		path := filepath.Join(t.TempDir(), "out.warc")
+		w := NewWARCWriter(path, 0)
+		wf := &WARCFetcher{Fetcher: site, Writer: w}
+
+		Convey("Fetch a page and check the request and response are recorded", func() {
+			req, _ := http.NewRequest("GET", "http://local.link/", nil)
+			resp, err := wf.Do(req)
+			So(err, ShouldBeNil)
+			body, _ := io.ReadAll(resp.Body)
+			So(string(body), ShouldEqual, "<html><head><title>Home</title></head></html>")
+			So(w.Close(), ShouldBeNil)
+
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "out-00000.warc"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldStartWith, "WARC/1.1\r\nWARC-Type: warcinfo\r\n")
+			So(string(data), ShouldContainSubstring, "WARC-Filename: out-00000.warc\r\n")
+			So(string(data), ShouldContainSubstring, "WARC-Type: response\r\n")
+			So(string(data), ShouldContainSubstring, "WARC-Type: request\r\n")
+			So(string(data), ShouldContainSubstring, "WARC-Target-URI: http://local.link/\r\n")
+			So(string(data), ShouldContainSubstring, "WARC-Payload-Digest: "+warcDigest(body)+"\r\n")
+			So(string(data), ShouldContainSubstring, "Content-Type: application/http;msgtype=response\r\n")
+			So(string(data), ShouldContainSubstring, "HTTP/1.1 200 OK\r\n")
+			So(string(data), ShouldContainSubstring, "GET / HTTP/1.1\r\n")
+		})
+	})
+
+	Convey("Given a WARC fetcher in front of a client which adds headers", t, func() {
+		var received http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, "<html><head><title>Home</title></head></html>")
+		}))
+		defer server.Close()
+
+		client, err := NewClient(&ClientOptions{
+			Headers:     http.Header{"X-Env": []string{"staging"}},
+			Credentials: map[string]*Credentials{"127.0.0.1": {Token: "secret"}},
+		})
+		So(err, ShouldBeNil)
+		path := filepath.Join(t.TempDir(), "out.warc")
+		w := NewWARCWriter(path, 0)
+		wf := &WARCFetcher{Fetcher: client, Writer: w}
+
+		Convey("Check that the request is recorded as it was sent", func() {
+			req, _ := http.NewRequest("GET", server.URL+"/", nil)
+			_, err := wf.Do(req)
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+			So(received.Get("X-Env"), ShouldEqual, "staging")
+
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "out-00000.warc"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, "User-Agent: "+DefaultUserAgent+"\r\n")
+			So(string(data), ShouldContainSubstring, "X-Env: staging\r\n")
+			So(string(data), ShouldContainSubstring, "Authorization: Bearer secret\r\n")
+			So(string(data), ShouldNotContainSubstring, "Go-http-client")
+		})
+	})
+
+	Convey("Given a WARC fetcher writing gzipped records to small files", t, func() {
+		dir := t.TempDir()
+		w := NewWARCWriter(filepath.Join(dir, "out.warc.gz"), 100)
+		wf := &WARCFetcher{Fetcher: site, Writer: w}
+
+		Convey("Fetch two pages and check that each went to its own file", func() {
+			req, _ := http.NewRequest("GET", "http://local.link/", nil)
+			_, err := wf.Do(req)
+			So(err, ShouldBeNil)
+			req, _ = http.NewRequest("GET", "http://local.link/a", nil)
+			_, err = wf.Do(req)
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "*"))
+			So(len(files), ShouldEqual, 2)
+			So(filepath.Base(files[0]), ShouldEqual, "out-00000.warc.gz")
+			So(filepath.Base(files[1]), ShouldEqual, "out-00001.warc.gz")
+
+			f, err := os.Open(files[1])
+			So(err, ShouldBeNil)
+			defer f.Close()
+			gz, err := gzip.NewReader(f)
+			So(err, ShouldBeNil)
+			data, err := io.ReadAll(gz)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, "WARC-Target-URI: http://local.link/a\r\n")
+			So(string(data), ShouldNotContainSubstring, "WARC-Target-URI: http://local.link/\r\n")
+		})
+	})
+}